@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+)
+
+// accountDeletionGracePeriod is how long a deleted account can be restored before it's purged.
+const accountDeletionGracePeriod = time.Hour * 24 * 30
+
+// handlerDeleteOwnUser schedules the account for deletion. It stops working
+// straight away, but isn't removed until the grace period is over.
+func handlerDeleteOwnUser(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var details struct {
+		Password string `json:"password"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// A stolen JWT shouldn't allow unlimited guesses at the password.
+	accountKey, ipKey := accountLoginKey(userDB.Email), ipLoginKey(req)
+	wait, err := loginRetryAfter(req.Context(), accountKey, ipKey)
+	if err != nil {
+		log.Printf("Error checking login failures: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		respondWithLoginLocked(w, wait)
+		return
+	}
+	err = auth.CheckPasswordHash(details.Password, userDB.HashedPassword)
+	if err != nil {
+		recordPasswordFailure(req, "delete_account", userDB.Email, userID, accountKey, ipKey)
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password")
+		return
+	}
+
+	err = apiCfg.DB.MarkUserDeleted(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error marking user deleted: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = apiCfg.DB.RevokeAllRefreshTokensForUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{Type: auditAccountDelete, ActorID: userID, TargetID: userID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerRestoreUser cancels a pending deletion. The user can't log in while
+// deletion is pending, so it takes an email and password instead of a token.
+func handlerRestoreUser(w http.ResponseWriter, req *http.Request) {
+	var details LoginDetails
+	err := json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	accountKey, ipKey := accountLoginKey(details.Email), ipLoginKey(req)
+	wait, err := loginRetryAfter(req.Context(), accountKey, ipKey)
+	if err != nil {
+		log.Printf("Error checking login failures: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		respondWithLoginLocked(w, wait)
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByEmail(req.Context(), details.Email)
+	if err == nil {
+		err = auth.CheckPasswordHash(details.Password, userDB.HashedPassword)
+	} else if errors.Is(err, sql.ErrNoRows) {
+		auth.CheckPasswordHash(details.Password, dummyPasswordHash())
+	} else {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		handleLoginFailure(w, req, details.Email, userDB.ID, accountKey, ipKey)
+		return
+	}
+
+	userDB, err = apiCfg.DB.RestoreUser(req.Context(), userDB.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Account isn't scheduled for deletion.")
+			return
+		}
+		log.Printf("Error restoring user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{Type: auditAccountRestore, ActorID: userDB.ID, TargetID: userDB.ID})
+	respondWithJSON(w, http.StatusOK, mapToUser(userDB))
+}
+
+// purgeDeletedUsers removes accounts whose grace period is over, every hour
+// until ctx is done. Everything they own is removed with them by ON DELETE CASCADE.
+func purgeDeletedUsers(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		rows, err := apiCfg.DB.PurgeDeletedUsers(ctx, sql.NullTime{
+			Time:  time.Now().Add(-accountDeletionGracePeriod),
+			Valid: true,
+		})
+		if err != nil {
+			log.Printf("Error purging deleted users: %v\n", err)
+		} else if rows > 0 {
+			log.Printf("Purged %d deleted users\n", rows)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
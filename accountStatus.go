@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const (
+	statusActive    = "active"
+	statusSuspended = "suspended"
+	statusBanned    = "banned"
+)
+
+var accountStatuses = []string{statusActive, statusSuspended, statusBanned}
+
+// accountRestrictedError is returned when a suspended or banned user tries to use their account.
+type accountRestrictedError struct {
+	Status    string
+	Reason    string
+	ExpiresAt *time.Time
+}
+
+func (e *accountRestrictedError) Error() string {
+	return "account is " + e.Status
+}
+
+// effectiveStatus treats restrictions that have expired as active, so nothing needs to clear them.
+func effectiveStatus(user database.User) string {
+	if user.Status != statusActive && user.StatusExpiresAt.Valid && !user.StatusExpiresAt.Time.After(time.Now()) {
+		return statusActive
+	}
+	return user.Status
+}
+
+func checkAccountStatus(user database.User) error {
+	if user.DeletedAt.Valid {
+		purgeAt := user.DeletedAt.Time.Add(accountDeletionGracePeriod)
+		return &accountRestrictedError{
+			Status:    "deleted",
+			Reason:    "The account can be restored until it's purged.",
+			ExpiresAt: &purgeAt,
+		}
+	}
+
+	status := effectiveStatus(user)
+	if status == statusActive {
+		return nil
+	}
+	err := &accountRestrictedError{Status: status, Reason: user.StatusReason}
+	if user.StatusExpiresAt.Valid {
+		err.ExpiresAt = &user.StatusExpiresAt.Time
+	}
+	return err
+}
+
+func respondWithAccountRestricted(w http.ResponseWriter, err *accountRestrictedError) {
+	respondWithJSON(w, http.StatusForbidden, struct {
+		Error     string     `json:"error"`
+		Reason    string     `json:"reason"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}{"This account has been " + err.Status + ".", err.Reason, err.ExpiresAt})
+}
+
+// handlerAdminSetUserStatus suspends, bans or reinstates a user. Restricting
+// a user also ends their sessions.
+func handlerAdminSetUserStatus(w http.ResponseWriter, req *http.Request) {
+	userID, ok := parseTargetUser(w, req, false)
+	if !ok {
+		return
+	}
+
+	var details struct {
+		Status    string     `json:"status"`
+		Reason    string     `json:"reason"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	if !slices.Contains(accountStatuses, details.Status) {
+		respondWithError(w, http.StatusBadRequest, "Unknown status: "+details.Status)
+		return
+	}
+
+	params := database.SetUserStatusParams{ID: userID, Status: details.Status}
+	if details.Status != statusActive {
+		if strings.TrimSpace(details.Reason) == "" {
+			respondWithError(w, http.StatusBadRequest, "A reason is required.")
+			return
+		}
+		if details.ExpiresAt != nil && !details.ExpiresAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "Expiry must be in the future.")
+			return
+		}
+		params.StatusReason = details.Reason
+		if details.ExpiresAt != nil {
+			params.StatusExpiresAt.Time = *details.ExpiresAt
+			params.StatusExpiresAt.Valid = true
+		}
+	}
+
+	userDB, err := apiCfg.DB.SetUserStatus(req.Context(), params)
+	if err != nil {
+		respondWithUserLookupError(w, err)
+		return
+	}
+
+	if details.Status != statusActive {
+		err = apiCfg.DB.RevokeAllRefreshTokensForUser(req.Context(), userID)
+		if err != nil {
+			log.Printf("Error revoking refresh tokens: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	recordAuditEvent(req, auditEvent{
+		Type:     auditAdminStatusChange,
+		ActorID:  userFromContext(req.Context()).ID,
+		TargetID: userID,
+		Details: map[string]any{
+			"status":     details.Status,
+			"reason":     params.StatusReason,
+			"expires_at": details.ExpiresAt,
+		},
+	})
+	respondWithJSON(w, http.StatusOK, mapToAdminUser(userDB))
+}
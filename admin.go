@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+// roles are ordered by privilege, each role can do everything the ones before it can.
+var roles = []string{roleUser, roleModerator, roleAdmin}
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+func hasRole(user database.User, role string) bool {
+	return slices.Index(roles, user.Role) >= slices.Index(roles, role)
+}
+
+// middlewareRequireRole only lets through users logged in with a JWT whose
+// role is at least role. The user is available to handlers with userFromContext.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		userID, err := authenticateUser(req, "")
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+
+		userDB, err := cfg.DB.GetUserByID(req.Context(), userID)
+		if err != nil {
+			log.Printf("Error retreiving user: %v\n", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !hasRole(userDB, role) {
+			respondWithError(w, http.StatusForbidden, "Invalid Permissions")
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), userContextKey, userDB)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+func userFromContext(ctx context.Context) database.User {
+	user, _ := ctx.Value(userContextKey).(database.User)
+	return user
+}
+
+func handlerSetUserRole(w http.ResponseWriter, req *http.Request) {
+	admin := userFromContext(req.Context())
+
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var details struct {
+		Role string `json:"role"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	if !slices.Contains(roles, details.Role) {
+		respondWithError(w, http.StatusBadRequest, "Unknown role: "+details.Role)
+		return
+	}
+	// Stops the last admin locking everyone out.
+	if userID == admin.ID && details.Role != roleAdmin {
+		respondWithError(w, http.StatusBadRequest, "Admins can't remove their own admin role.")
+		return
+	}
+
+	params := database.SetUserRoleParams{ID: userID, Role: details.Role}
+	userDB, err := apiCfg.DB.SetUserRole(req.Context(), params)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "User not found.")
+			return
+		}
+		log.Printf("Error setting user role: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{
+		Type:     auditAdminRoleChange,
+		ActorID:  admin.ID,
+		TargetID: userDB.ID,
+		Details:  map[string]any{"role": userDB.Role},
+	})
+	respondWithJSON(w, http.StatusOK, mapToUser(userDB))
+}
+
+// runCommand handles command line tasks, like `go run . promote-admin [email]`.
+func runCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "promote-admin":
+		if len(args) != 2 {
+			return errors.New("usage: chirpy promote-admin [email]")
+		}
+		return promoteFirstAdmin(ctx, args[1])
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// promoteFirstAdmin bootstraps the first admin. Once there is one, roles can
+// only be changed by an admin.
+func promoteFirstAdmin(ctx context.Context, email string) error {
+	userDB, err := apiCfg.DB.PromoteFirstAdmin(ctx, email)
+	if err == nil {
+		log.Printf("Promoted %v to admin\n", userDB.Email)
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = apiCfg.DB.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user with email %v", email)
+	}
+	if err != nil {
+		return err
+	}
+	return errors.New("an admin already exists, ask them to change roles instead")
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+type Session struct {
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ClientID  *string   `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+}
+
+func mapToSession(from database.GetSessionsByUserRow) Session {
+	session := Session{CreatedAt: from.CreatedAt, ExpiresAt: from.ExpiresAt, Scopes: from.Scopes}
+	if from.ClientID.Valid {
+		session.ClientID = &from.ClientID.String
+	}
+	return session
+}
+
+// AdminUser includes the fields only admins should see.
+type AdminUser struct {
+	User
+	StatusReason    string     `json:"status_reason,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+}
+
+func mapToAdminUser(from database.User) AdminUser {
+	user := AdminUser{User: mapToUser(from)}
+	if user.Status != statusActive {
+		user.StatusReason = from.StatusReason
+		if from.StatusExpiresAt.Valid {
+			user.StatusExpiresAt = &from.StatusExpiresAt.Time
+		}
+	}
+	return user
+}
+
+// parseTargetUser reads the user id from the path. Admins can't target
+// themselves with actions that could lock them out.
+func parseTargetUser(w http.ResponseWriter, req *http.Request, allowSelf bool) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return uuid.UUID{}, false
+	}
+	if !allowSelf && userID == userFromContext(req.Context()).ID {
+		respondWithError(w, http.StatusBadRequest, "Admins can't do this to their own account.")
+		return uuid.UUID{}, false
+	}
+	return userID, true
+}
+
+func respondWithUserLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found.")
+		return
+	}
+	log.Printf("Error retreiving user: %v\n", err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func handlerAdminGetUsers(w http.ResponseWriter, req *http.Request) {
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.SearchUsersParams{PageLimit: p.Limit, PageOffset: p.Offset}
+	if query := strings.TrimSpace(req.URL.Query().Get("q")); query != "" {
+		params.Query = sql.NullString{String: query, Valid: true}
+	}
+	usersDB, err := apiCfg.DB.SearchUsers(req.Context(), params)
+	if err != nil {
+		log.Printf("Error searching users: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	users := []AdminUser{}
+	for _, user := range usersDB {
+		users = append(users, mapToAdminUser(user))
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+func handlerAdminGetUser(w http.ResponseWriter, req *http.Request) {
+	userID, ok := parseTargetUser(w, req, true)
+	if !ok {
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithUserLookupError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, mapToAdminUser(userDB))
+}
+
+func handlerAdminGetUserChirps(w http.ResponseWriter, req *http.Request) {
+	userID, ok := parseTargetUser(w, req, true)
+	if !ok {
+		return
+	}
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetChirpsByUserPageParams{UserID: userID, PageLimit: p.Limit, PageOffset: p.Offset}
+	chirpsDB, err := apiCfg.DB.GetChirpsByUserPage(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving chirps: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, chirp := range chirpsDB {
+		chirps = append(chirps, mapToChirp(chirp))
+	}
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+func handlerAdminGetUserSessions(w http.ResponseWriter, req *http.Request) {
+	userID, ok := parseTargetUser(w, req, true)
+	if !ok {
+		return
+	}
+
+	sessionsDB, err := apiCfg.DB.GetSessionsByUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving sessions: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sessions := []Session{}
+	for _, session := range sessionsDB {
+		sessions = append(sessions, mapToSession(session))
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// handlerAdminLogoutUser revokes every refresh token, access tokens expire on their own within the hour.
+func handlerAdminLogoutUser(w http.ResponseWriter, req *http.Request) {
+	userID, ok := parseTargetUser(w, req, true)
+	if !ok {
+		return
+	}
+
+	_, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithUserLookupError(w, err)
+		return
+	}
+
+	err = apiCfg.DB.RevokeAllRefreshTokensForUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{
+		Type:     auditAdminForceLogout,
+		ActorID:  userFromContext(req.Context()).ID,
+		TargetID: userID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlerAdminSetChirpyRed(w http.ResponseWriter, req *http.Request) {
+	userID, ok := parseTargetUser(w, req, true)
+	if !ok {
+		return
+	}
+
+	var details struct {
+		IsChirpyRed *bool `json:"is_chirpy_red"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&details)
+	if err != nil || details.IsChirpyRed == nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	params := database.SetUserChirpyRedParams{ID: userID, IsChirpyRed: *details.IsChirpyRed}
+	userDB, err := apiCfg.DB.SetUserChirpyRed(req.Context(), params)
+	if err != nil {
+		respondWithUserLookupError(w, err)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{
+		Type:     auditAdminChirpyRed,
+		ActorID:  userFromContext(req.Context()).ID,
+		TargetID: userID,
+		Details:  map[string]any{"is_chirpy_red": userDB.IsChirpyRed},
+	})
+	respondWithJSON(w, http.StatusOK, mapToAdminUser(userDB))
+}
+
+// handlerAdminDeleteUser removes the user, their chirps and tokens go with them.
+func handlerAdminDeleteUser(w http.ResponseWriter, req *http.Request) {
+	userID, ok := parseTargetUser(w, req, false)
+	if !ok {
+		return
+	}
+
+	rows, err := apiCfg.DB.RemoveUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error removing user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "User not found.")
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{
+		Type:     auditAdminDeleteUser,
+		ActorID:  userFromContext(req.Context()).ID,
+		TargetID: userID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
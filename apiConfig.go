@@ -1,19 +1,32 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"net/http"
 	"sync/atomic"
 
+	"github.com/samthesomebody/chirpy/internal/auth"
 	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/mail"
+	"github.com/samthesomebody/chirpy/internal/oidc"
+	"github.com/samthesomebody/chirpy/internal/webauthn"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	DB             database.Queries
-	Platform       string
-	TokenSecret    string
-	PolkaKey       string
+	fileserverHits       atomic.Int32
+	DB                   database.Queries
+	Conn                 *sql.DB // for transactions, see withTx
+	Platform             string
+	TokenSecret          string
+	PolkaKey             string
+	BaseURL              string
+	Mailer               mail.Sender
+	RequireVerifiedEmail bool
+	WebAuthn             webauthn.Config
+	OIDC                 *oidc.Provider
+	PasswordPolicy       auth.PasswordPolicy
+	Stream               *streamHub
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -34,10 +47,3 @@ func (cfg *apiConfig) getFileserverHits(w http.ResponseWriter, req *http.Request
 </html>`, cfg.fileserverHits.Load())
 	w.Write([]byte(output))
 }
-
-func (cfg *apiConfig) resetFileserverHits(w http.ResponseWriter, req *http.Request) {
-	cfg.fileserverHits.Store(0)
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Reset fileserver hits."))
-}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const (
+	scopeChirpsWrite  = "chirps:write"
+	scopeChirpsDelete = "chirps:delete"
+)
+
+// delegatedScopes can be granted to API keys and third party apps.
+var delegatedScopes = []string{scopeChirpsWrite, scopeChirpsDelete}
+
+type ApiKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+func mapToApiKey(from database.ApiKey) ApiKey {
+	key := ApiKey{
+		ID:        from.ID,
+		CreatedAt: from.CreatedAt,
+		Name:      from.Name,
+		Prefix:    from.Prefix,
+		Scopes:    from.Scopes,
+	}
+	if from.ExpiresAt.Valid {
+		key.ExpiresAt = &from.ExpiresAt.Time
+	}
+	if from.LastUsedAt.Valid {
+		key.LastUsedAt = &from.LastUsedAt.Time
+	}
+	return key
+}
+
+func handlerAddApiKey(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var details struct {
+		Name             string   `json:"name"`
+		Scopes           []string `json:"scopes"`
+		ExpiresInSeconds int      `json:"expires_in_seconds"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	if details.Name == "" || len(details.Name) > 64 {
+		respondWithError(w, http.StatusBadRequest, "Name must be between 1 and 64 characters.")
+		return
+	}
+	if len(details.Scopes) == 0 {
+		details.Scopes = delegatedScopes
+	}
+	for _, scope := range details.Scopes {
+		if !slices.Contains(delegatedScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, "Unknown scope: "+scope)
+			return
+		}
+	}
+	if details.ExpiresInSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "Expiry must be positive.")
+		return
+	}
+
+	key, err := auth.MakeApiKey()
+	if err != nil {
+		log.Printf("Error generating api key: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.CreateApiKeyParams{
+		UserID:  userID,
+		Name:    details.Name,
+		Prefix:  key[:len(auth.ApiKeyPrefix)+6],
+		KeyHash: auth.HashToken(key),
+		Scopes:  details.Scopes,
+	}
+	if details.ExpiresInSeconds > 0 {
+		params.ExpiresAt = sql.NullTime{
+			Time:  time.Now().Add(time.Duration(details.ExpiresInSeconds) * time.Second),
+			Valid: true,
+		}
+	}
+	keyDB, err := apiCfg.DB.CreateApiKey(req.Context(), params)
+	if err != nil {
+		log.Printf("Error creating api key database entry: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The key is only ever shown here, the database keeps a hash.
+	apiKey := mapToApiKey(keyDB)
+	apiKey.Key = key
+	respondWithJSON(w, http.StatusCreated, apiKey)
+}
+
+func handlerGetApiKeys(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	keysDB, err := apiCfg.DB.GetApiKeysByUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving api keys: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	keys := []ApiKey{}
+	for _, key := range keysDB {
+		keys = append(keys, mapToApiKey(key))
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func handlerRevokeApiKey(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	keyID, err := uuid.Parse(req.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid key id")
+		return
+	}
+
+	params := database.RevokeApiKeyParams{ID: keyID, UserID: userID}
+	rows, err := apiCfg.DB.RevokeApiKey(req.Context(), params)
+	if err != nil {
+		log.Printf("Error revoking api key: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "API key not found.")
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{
+		Type:     auditApiKeyRevoke,
+		ActorID:  userID,
+		TargetID: userID,
+		Details:  map[string]any{"key_id": keyID},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
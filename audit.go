@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const (
+	auditLoginSuccess       = "login.success"
+	auditLoginFailure       = "login.failure"
+	auditLoginLocked        = "login.locked"
+	auditPasswordChange     = "password.change"
+	auditPasswordReset      = "password.reset"
+	auditEmailChange        = "email.change"
+	auditMFADisable         = "mfa.disable"
+	auditAccountDelete      = "account.delete"
+	auditAccountRestore     = "account.restore"
+	auditDataExportRequest  = "data_export.request"
+	auditRefreshTokenRevoke = "refresh_token.revoke"
+	auditApiKeyRevoke       = "api_key.revoke"
+	auditOAuthConsentRevoke = "oauth_consent.revoke"
+	auditChirpDelete        = "chirp.delete"
+	auditWebhookUpgrade     = "webhook.upgrade"
+	auditAdminRoleChange    = "admin.role_change"
+	auditAdminStatusChange  = "admin.status_change"
+	auditAdminForceLogout   = "admin.force_logout"
+	auditAdminChirpyRed     = "admin.chirpy_red"
+	auditAdminDeleteUser    = "admin.delete_user"
+)
+
+const requestIDContextKey contextKey = "request_id"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// middlewareRequestID tags each request with an id, reusing the X-Request-ID
+// header from a proxy if there is one, so log lines and audit events can be matched up.
+func (cfg *apiConfig) middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestID := req.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(requestID) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			requestID = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		ctx := context.WithValue(req.Context(), requestIDContextKey, requestID)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
+
+type auditEvent struct {
+	Type     string
+	ActorID  uuid.UUID // uuid.Nil if the actor isn't known
+	TargetID uuid.UUID // uuid.Nil if the event has no target
+	Details  map[string]any
+}
+
+// recordAuditEvent saves the event along with where the request came from.
+// Failures are logged rather than failing the request.
+func recordAuditEvent(req *http.Request, event auditEvent) {
+	details, err := json.Marshal(event.Details)
+	if err != nil || event.Details == nil {
+		details = []byte("{}")
+	}
+
+	params := database.CreateAuditEventParams{
+		EventType: event.Type,
+		ActorID:   uuid.NullUUID{UUID: event.ActorID, Valid: event.ActorID != uuid.Nil},
+		TargetID:  uuid.NullUUID{UUID: event.TargetID, Valid: event.TargetID != uuid.Nil},
+		Ip:        clientIP(req),
+		UserAgent: req.UserAgent(),
+		RequestID: requestIDFromContext(req.Context()),
+		Details:   details,
+	}
+	err = apiCfg.DB.CreateAuditEvent(req.Context(), params)
+	if err != nil {
+		log.Printf("Error recording audit event [%v]: %v\n", event.Type, err)
+	}
+}
+
+type AuditEvent struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	EventType string          `json:"event_type"`
+	ActorID   *uuid.UUID      `json:"actor_id"`
+	TargetID  *uuid.UUID      `json:"target_id"`
+	IP        string          `json:"ip"`
+	UserAgent string          `json:"user_agent"`
+	RequestID string          `json:"request_id"`
+	Details   json.RawMessage `json:"details"`
+}
+
+func mapToAuditEvent(from database.AuditEvent) AuditEvent {
+	event := AuditEvent{
+		ID:        from.ID,
+		CreatedAt: from.CreatedAt,
+		EventType: from.EventType,
+		IP:        from.Ip,
+		UserAgent: from.UserAgent,
+		RequestID: from.RequestID,
+		Details:   from.Details,
+	}
+	if from.ActorID.Valid {
+		event.ActorID = &from.ActorID.UUID
+	}
+	if from.TargetID.Valid {
+		event.TargetID = &from.TargetID.UUID
+	}
+	return event
+}
+
+func handlerGetAuditEvents(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	params := database.GetAuditEventsParams{MaxResults: 100}
+
+	if eventType := query.Get("event_type"); eventType != "" {
+		params.EventType = sql.NullString{String: eventType, Valid: true}
+	}
+	if ip := query.Get("ip"); ip != "" {
+		params.Ip = sql.NullString{String: ip, Valid: true}
+	}
+	for _, filter := range []struct {
+		name  string
+		value *uuid.NullUUID
+	}{{"actor_id", &params.ActorID}, {"target_id", &params.TargetID}} {
+		raw := query.Get(filter.name)
+		if raw == "" {
+			continue
+		}
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid "+filter.name)
+			return
+		}
+		*filter.value = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	for _, filter := range []struct {
+		name  string
+		value *sql.NullTime
+	}{{"since", &params.Since}, {"until", &params.Until}} {
+		raw := query.Get(filter.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, filter.name+" must be an RFC 3339 timestamp")
+			return
+		}
+		*filter.value = sql.NullTime{Time: t.UTC(), Valid: true}
+	}
+	if raw := query.Get("before_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid before_id")
+			return
+		}
+		params.BeforeID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > 1000 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		params.MaxResults = int32(limit)
+	}
+
+	eventsDB, err := apiCfg.DB.GetAuditEvents(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving audit events: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	events := []AuditEvent{}
+	for _, event := range eventsDB {
+		events = append(events, mapToAuditEvent(event))
+	}
+	respondWithJSON(w, http.StatusOK, events)
+}
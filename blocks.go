@@ -0,0 +1,178 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+type BlockedUser struct {
+	Profile
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+type MutedUser struct {
+	Profile
+	MutedAt time.Time `json:"muted_at"`
+}
+
+// parseRelationshipTarget authenticates the user and reads who they want to
+// block or mute from the path.
+func parseRelationshipTarget(w http.ResponseWriter, req *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	targetID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+	if targetID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't do this to your own account.")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	_, err = apiCfg.DB.GetUserByID(req.Context(), targetID)
+	if err != nil {
+		respondWithUserLookupError(w, err)
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+	return userID, targetID, true
+}
+
+// handlerBlockUser hides the two users from each other. Blocking someone
+// twice isn't an error.
+func handlerBlockUser(w http.ResponseWriter, req *http.Request) {
+	userID, targetID, ok := parseRelationshipTarget(w, req)
+	if !ok {
+		return
+	}
+
+	params := database.BlockUserParams{BlockerID: userID, BlockedID: targetID}
+	err := apiCfg.DB.BlockUser(req.Context(), params)
+	if err != nil {
+		log.Printf("Error blocking user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlerUnblockUser(w http.ResponseWriter, req *http.Request) {
+	userID, targetID, ok := parseRelationshipTarget(w, req)
+	if !ok {
+		return
+	}
+
+	params := database.UnblockUserParams{BlockerID: userID, BlockedID: targetID}
+	rows, err := apiCfg.DB.UnblockUser(req.Context(), params)
+	if err != nil {
+		log.Printf("Error unblocking user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "User isn't blocked.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlerGetBlockedUsers(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetBlockedUsersParams{BlockerID: userID, PageLimit: p.Limit, PageOffset: p.Offset}
+	blockedDB, err := apiCfg.DB.GetBlockedUsers(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving blocked users: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	users := []BlockedUser{}
+	for _, blocked := range blockedDB {
+		users = append(users, BlockedUser{mapToProfile(blocked.User), blocked.BlockedAt})
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// handlerMuteUser hides the user's chirps from the muter's timeline. Unlike a
+// block, the muted user isn't told and can still see the muter.
+func handlerMuteUser(w http.ResponseWriter, req *http.Request) {
+	userID, targetID, ok := parseRelationshipTarget(w, req)
+	if !ok {
+		return
+	}
+
+	params := database.MuteUserParams{MuterID: userID, MutedID: targetID}
+	err := apiCfg.DB.MuteUser(req.Context(), params)
+	if err != nil {
+		log.Printf("Error muting user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlerUnmuteUser(w http.ResponseWriter, req *http.Request) {
+	userID, targetID, ok := parseRelationshipTarget(w, req)
+	if !ok {
+		return
+	}
+
+	params := database.UnmuteUserParams{MuterID: userID, MutedID: targetID}
+	rows, err := apiCfg.DB.UnmuteUser(req.Context(), params)
+	if err != nil {
+		log.Printf("Error unmuting user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "User isn't muted.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlerGetMutedUsers(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetMutedUsersParams{MuterID: userID, PageLimit: p.Limit, PageOffset: p.Offset}
+	mutedDB, err := apiCfg.DB.GetMutedUsers(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving muted users: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	users := []MutedUser{}
+	for _, muted := range mutedDB {
+		users = append(users, MutedUser{mapToProfile(muted.User), muted.MutedAt})
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+type BookmarkedChirp struct {
+	Chirp
+	BookmarkedAt time.Time `json:"bookmarked_at"`
+}
+
+// handlerAddBookmark bookmarks a chirp the user can see. Bookmarking it twice isn't an error.
+func handlerAddBookmark(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+		return
+	}
+
+	visible := database.GetVisibleChirpParams{ID: chirpID, ViewerID: uuid.NullUUID{UUID: userID, Valid: true}}
+	_, err = apiCfg.DB.GetVisibleChirp(req.Context(), visible)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Chirp not found.")
+			return
+		}
+		log.Printf("Error retreiving chirp: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.AddBookmarkParams{UserID: userID, ChirpID: chirpID}
+	err = apiCfg.DB.AddBookmark(req.Context(), params)
+	if err != nil {
+		log.Printf("Error adding bookmark: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlerRemoveBookmark(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+		return
+	}
+
+	params := database.RemoveBookmarkParams{UserID: userID, ChirpID: chirpID}
+	rows, err := apiCfg.DB.RemoveBookmark(req.Context(), params)
+	if err != nil {
+		log.Printf("Error removing bookmark: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Chirp isn't bookmarked.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerGetBookmarks lists the user's bookmarks, most recently bookmarked first.
+func handlerGetBookmarks(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetBookmarkedChirpsParams{UserID: userID, PageLimit: p.Limit, PageOffset: p.Offset}
+	bookmarksDB, err := apiCfg.DB.GetBookmarkedChirps(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving bookmarks: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	bookmarks := []BookmarkedChirp{}
+	for _, bookmark := range bookmarksDB {
+		bookmarks = append(bookmarks, BookmarkedChirp{mapToChirp(bookmark.Chirp), bookmark.BookmarkedAt})
+	}
+	respondWithJSON(w, http.StatusOK, bookmarks)
+}
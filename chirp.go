@@ -13,7 +13,6 @@ import (
 
 	"github.com/google/uuid"
 
-	"github.com/samthesomebody/chirpy/internal/auth"
 	"github.com/samthesomebody/chirpy/internal/database"
 )
 
@@ -23,6 +22,7 @@ type Chirp struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
 	UserID    uuid.UUID `json:"user_id"`
+	Pinned    bool      `json:"pinned,omitempty"`
 }
 
 func mapToChirp(from database.Chirp) Chirp {
@@ -36,18 +36,23 @@ func mapToChirp(from database.Chirp) Chirp {
 }
 
 func handlerAddChirp(w http.ResponseWriter, req *http.Request) {
-	token, err := auth.GetBearerToken(req.Header)
+	id, err := authenticateUser(req, scopeChirpsWrite)
 	if err != nil {
-		log.Printf("Error getting authorization header: %v\n", err)
-		w.WriteHeader(http.StatusUnauthorized)
+		respondWithAuthError(w, err)
 		return
 	}
 
-	id, err := auth.ValidateJWT(token, apiCfg.TokenSecret)
-	if err != nil {
-		log.Printf("Error validating JWT: %v [%v]\n", err, token)
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+	if apiCfg.RequireVerifiedEmail {
+		userDB, err := apiCfg.DB.GetUserByID(req.Context(), id)
+		if err != nil {
+			log.Printf("Error retreiving user: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !userDB.IsVerified {
+			respondWithError(w, http.StatusForbidden, "Email address must be verified before posting.")
+			return
+		}
 	}
 
 	var chirp Chirp
@@ -72,6 +77,7 @@ func handlerAddChirp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	publishStreamEvent(req.Context(), streamChirpCreated, id, nil, mapToChirp(chirpDB))
 	respondWithJSON(w, http.StatusCreated, mapToChirp(chirpDB))
 }
 
@@ -89,17 +95,25 @@ func replaceProfanities(msg string) string {
 
 func handlerGetChirps(w http.ResponseWriter, req *http.Request) {
 	author_id := req.URL.Query().Get("author_id")
+	viewer := viewerID(req)
 	var chirpsDB []database.Chirp
+	var pinnedID uuid.NullUUID
 	var err error
 	if author_id == "" {
-		chirpsDB, err = apiCfg.DB.GetChirps(req.Context())
+		chirpsDB, err = apiCfg.DB.GetChirps(req.Context(), viewer)
 	} else {
-		id, err := uuid.Parse(author_id)
-		if err != nil {
+		id, parseErr := uuid.Parse(author_id)
+		if parseErr != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid user id")
 			return
 		}
-		chirpsDB, err = apiCfg.DB.GetChirpsByUser(req.Context(), id)
+		params := database.GetChirpsByUserParams{UserID: id, ViewerID: viewer}
+		chirpsDB, err = apiCfg.DB.GetChirpsByUser(req.Context(), params)
+		if err == nil && len(chirpsDB) > 0 {
+			var author database.User
+			author, err = apiCfg.DB.GetUserByID(req.Context(), id)
+			pinnedID = author.PinnedChirpID
+		}
 	}
 	if err != nil {
 		log.Printf("Error retreiving chirps: %v\n", err)
@@ -120,6 +134,19 @@ func handlerGetChirps(w http.ResponseWriter, req *http.Request) {
 			func(i, j int) bool { return chirps[j].CreatedAt.Before(chirps[i].CreatedAt) },
 		)
 	}
+
+	// The author's pinned chirp goes first whichever way the rest are sorted.
+	if pinnedID.Valid {
+		i := slices.IndexFunc(chirps, func(c Chirp) bool { return c.ID == pinnedID.UUID })
+		if i > 0 {
+			pinned := chirps[i]
+			copy(chirps[1:i+1], chirps[:i])
+			chirps[0] = pinned
+		}
+		if i >= 0 {
+			chirps[0].Pinned = true
+		}
+	}
 	respondWithJSON(w, http.StatusOK, chirps)
 }
 
@@ -131,7 +158,8 @@ func handlerGetChirp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	chirp, err := apiCfg.DB.GetChirp(req.Context(), id)
+	params := database.GetVisibleChirpParams{ID: id, ViewerID: viewerID(req)}
+	chirp, err := apiCfg.DB.GetVisibleChirp(req.Context(), params)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			respondWithError(w, http.StatusNotFound, "Chirp not found.")
@@ -146,17 +174,9 @@ func handlerGetChirp(w http.ResponseWriter, req *http.Request) {
 }
 
 func handlerDeleteChirp(w http.ResponseWriter, req *http.Request) {
-	token, err := auth.GetBearerToken(req.Header)
+	userID, err := authenticateUser(req, scopeChirpsDelete)
 	if err != nil {
-		log.Printf("Error getting refresh token: %v\n", err)
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, apiCfg.TokenSecret)
-	if err != nil {
-		log.Printf("Error validating JWT: %v\n", err)
-		w.WriteHeader(http.StatusUnauthorized)
+		respondWithAuthError(w, err)
 		return
 	}
 
@@ -183,6 +203,7 @@ func handlerDeleteChirp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Bookmarks of the chirp are removed with it by ON DELETE CASCADE.
 	err = apiCfg.DB.RemoveChirp(req.Context(), chirpID)
 	if err != nil {
 		log.Printf("Error removing chirp: %v\n", err)
@@ -190,5 +211,85 @@ func handlerDeleteChirp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	recordAuditEvent(req, auditEvent{
+		Type:     auditChirpDelete,
+		ActorID:  userID,
+		TargetID: chirp.UserID,
+		Details:  map[string]any{"chirp_id": chirpID},
+	})
+	publishStreamEvent(req.Context(), streamChirpDeleted, userID, nil, map[string]any{"id": chirpID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPinChirp pins one of the user's own chirps to their profile,
+// replacing any chirp that was pinned before.
+func handlerPinChirp(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+		return
+	}
+
+	chirp, err := apiCfg.DB.GetChirp(req.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Chirp not found.")
+			return
+		}
+		log.Printf("Error retreiving chirp: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if chirp.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Only the author can pin a chirp.")
+		return
+	}
+
+	params := database.PinChirpParams{UserID: userID, ChirpID: uuid.NullUUID{UUID: chirpID, Valid: true}}
+	userDB, err := apiCfg.DB.PinChirp(req.Context(), params)
+	if err != nil {
+		// The chirp was deleted after it was looked up.
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Chirp not found.")
+			return
+		}
+		log.Printf("Error pinning chirp: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, mapToUser(userDB))
+}
+
+func handlerUnpinChirp(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+		return
+	}
+
+	params := database.UnpinChirpParams{ID: userID, PinnedChirpID: uuid.NullUUID{UUID: chirpID, Valid: true}}
+	rows, err := apiCfg.DB.UnpinChirp(req.Context(), params)
+	if err != nil {
+		log.Printf("Error unpinning chirp: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Chirp isn't pinned.")
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
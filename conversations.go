@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const (
+	maxConversationParticipants = 10
+	maxMessageLength            = 1000
+)
+
+type Participant struct {
+	UserID     uuid.UUID `json:"user_id"`
+	JoinedAt   time.Time `json:"joined_at"`
+	LastReadAt time.Time `json:"last_read_at"`
+}
+
+type Conversation struct {
+	ID           uuid.UUID     `json:"id"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	IsGroup      bool          `json:"is_group"`
+	Participants []Participant `json:"participants"`
+}
+
+type ConversationSummary struct {
+	ID             uuid.UUID   `json:"id"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	IsGroup        bool        `json:"is_group"`
+	ParticipantIDs []uuid.UUID `json:"participant_ids"`
+	UnreadCount    int64       `json:"unread_count"`
+}
+
+type Message struct {
+	ID             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	SenderID       uuid.UUID `json:"sender_id"`
+	Body           string    `json:"body"`
+}
+
+func mapToMessage(from database.Message) Message {
+	return Message{
+		ID:             from.ID,
+		CreatedAt:      from.CreatedAt,
+		ConversationID: from.ConversationID,
+		SenderID:       from.SenderID,
+		Body:           from.Body,
+	}
+}
+
+// validateMessageBody trims the body and checks its length.
+func validateMessageBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(body) > maxMessageLength {
+		return "", fmt.Errorf("message must be between 1 and %d characters", maxMessageLength)
+	}
+	return body, nil
+}
+
+// publishMessage streams the message to the conversation's other participants.
+func publishMessage(req *http.Request, message database.Message) {
+	participantsDB, err := apiCfg.DB.GetConversationParticipants(req.Context(), message.ConversationID)
+	if err != nil {
+		log.Printf("Error retreiving conversation participants: %v\n", err)
+		return
+	}
+
+	recipients := []uuid.UUID{}
+	for _, p := range participantsDB {
+		if p.UserID != message.SenderID {
+			recipients = append(recipients, p.UserID)
+		}
+	}
+	publishStreamEvent(req.Context(), streamMessageCreated, message.SenderID, recipients, mapToMessage(message))
+}
+
+// respondWithConversation includes the participants and when each last read the conversation.
+func respondWithConversation(w http.ResponseWriter, req *http.Request, code int, conversationDB database.Conversation) {
+	participantsDB, err := apiCfg.DB.GetConversationParticipants(req.Context(), conversationDB.ID)
+	if err != nil {
+		log.Printf("Error retreiving conversation participants: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	conversation := Conversation{
+		ID:           conversationDB.ID,
+		CreatedAt:    conversationDB.CreatedAt,
+		UpdatedAt:    conversationDB.UpdatedAt,
+		IsGroup:      conversationDB.IsGroup,
+		Participants: []Participant{},
+	}
+	for _, p := range participantsDB {
+		conversation.Participants = append(conversation.Participants, Participant{p.UserID, p.JoinedAt, p.LastReadAt})
+	}
+	respondWithJSON(w, code, conversation)
+}
+
+// getParticipatingConversation authenticates the user and reads the
+// conversation from the path. Conversations the user isn't in aren't found.
+func getParticipatingConversation(w http.ResponseWriter, req *http.Request) (uuid.UUID, database.Conversation, bool) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return uuid.UUID{}, database.Conversation{}, false
+	}
+
+	conversationID, err := uuid.Parse(req.PathValue("conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversation id")
+		return uuid.UUID{}, database.Conversation{}, false
+	}
+
+	params := database.GetConversationForParticipantParams{ID: conversationID, UserID: userID}
+	conversationDB, err := apiCfg.DB.GetConversationForParticipant(req.Context(), params)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Conversation not found.")
+			return uuid.UUID{}, database.Conversation{}, false
+		}
+		log.Printf("Error retreiving conversation: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return uuid.UUID{}, database.Conversation{}, false
+	}
+	return userID, conversationDB, true
+}
+
+// startDirectConversation returns the two users' conversation, creating it if
+// there isn't one. If another request creates it first, that one is returned.
+func startDirectConversation(ctx context.Context, userID, otherUserID uuid.UUID) (database.Conversation, int, error) {
+	params := database.GetDirectConversationParams{UserID: userID, OtherUserID: otherUserID}
+	conversationDB, err := apiCfg.DB.GetDirectConversation(ctx, params)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return conversationDB, http.StatusOK, err
+	}
+	conversationDB, err = apiCfg.DB.CreateDirectConversation(ctx, database.CreateDirectConversationParams(params))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return conversationDB, http.StatusCreated, err
+	}
+	conversationDB, err = apiCfg.DB.GetDirectConversation(ctx, params)
+	return conversationDB, http.StatusOK, err
+}
+
+// handlerAddConversation starts a conversation with one or more other users.
+// Starting a one to one conversation that already exists returns it instead,
+// and users who've blocked the sender, or been blocked by them, can't be added.
+func handlerAddConversation(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var details struct {
+		ParticipantIDs []uuid.UUID `json:"participant_ids"`
+		Body           *string     `json:"body"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	participants := []uuid.UUID{userID}
+	for _, id := range details.ParticipantIDs {
+		if !slices.Contains(participants, id) {
+			participants = append(participants, id)
+		}
+	}
+	if len(participants) < 2 || len(participants) > maxConversationParticipants {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Conversations need between 1 and %d other participants.", maxConversationParticipants-1))
+		return
+	}
+	var body string
+	if details.Body != nil {
+		body, err = validateMessageBody(*details.Body)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	for _, id := range participants[1:] {
+		_, err = getVisibleUser(req, id.String())
+		if err != nil {
+			respondWithUserLookupError(w, err)
+			return
+		}
+	}
+
+	var code int
+	var conversationDB database.Conversation
+	if len(participants) == 2 {
+		conversationDB, code, err = startDirectConversation(req.Context(), userID, participants[1])
+	} else {
+		code = http.StatusCreated
+		conversationDB, err = apiCfg.DB.CreateGroupConversation(req.Context(), participants)
+	}
+	if err != nil {
+		log.Printf("Error creating conversation: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if body != "" {
+		params := database.CreateMessageParams{ConversationID: conversationDB.ID, SenderID: userID, Body: body}
+		messageDB, err := apiCfg.DB.CreateMessage(req.Context(), params)
+		if err != nil {
+			log.Printf("Error creating message: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		publishMessage(req, messageDB)
+	}
+
+	respondWithConversation(w, req, code, conversationDB)
+}
+
+func handlerGetConversations(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetConversationsByUserParams{UserID: userID, PageLimit: p.Limit, PageOffset: p.Offset}
+	conversationsDB, err := apiCfg.DB.GetConversationsByUser(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving conversations: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	conversations := []ConversationSummary{}
+	for _, c := range conversationsDB {
+		conversations = append(conversations, ConversationSummary{
+			ID:             c.ID,
+			CreatedAt:      c.CreatedAt,
+			UpdatedAt:      c.UpdatedAt,
+			IsGroup:        c.IsGroup,
+			ParticipantIDs: c.ParticipantIds,
+			UnreadCount:    c.UnreadCount,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, conversations)
+}
+
+func handlerGetConversation(w http.ResponseWriter, req *http.Request) {
+	_, conversationDB, ok := getParticipatingConversation(w, req)
+	if !ok {
+		return
+	}
+	respondWithConversation(w, req, http.StatusOK, conversationDB)
+}
+
+// handlerAddMessage sends a message. In a one to one conversation it's
+// refused if either user has blocked the other, in a group the message is
+// only hidden from those who have.
+func handlerAddMessage(w http.ResponseWriter, req *http.Request) {
+	userID, conversationDB, ok := getParticipatingConversation(w, req)
+	if !ok {
+		return
+	}
+
+	var details struct {
+		Body string `json:"body"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	body, err := validateMessageBody(details.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !conversationDB.IsGroup {
+		participantsDB, err := apiCfg.DB.GetConversationParticipants(req.Context(), conversationDB.ID)
+		if err != nil {
+			log.Printf("Error retreiving conversation participants: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, p := range participantsDB {
+			if p.UserID == userID {
+				continue
+			}
+			params := database.IsBlockedEitherWayParams{BlockerID: userID, BlockedID: p.UserID}
+			blocked, err := apiCfg.DB.IsBlockedEitherWay(req.Context(), params)
+			if err != nil {
+				log.Printf("Error checking blocks: %v\n", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if blocked {
+				respondWithError(w, http.StatusForbidden, "You can't message this user.")
+				return
+			}
+		}
+	}
+
+	params := database.CreateMessageParams{ConversationID: conversationDB.ID, SenderID: userID, Body: body}
+	messageDB, err := apiCfg.DB.CreateMessage(req.Context(), params)
+	if err != nil {
+		log.Printf("Error creating message: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	publishMessage(req, messageDB)
+	respondWithJSON(w, http.StatusCreated, mapToMessage(messageDB))
+}
+
+// handlerGetMessages lists a conversation's messages, newest first.
+func handlerGetMessages(w http.ResponseWriter, req *http.Request) {
+	userID, conversationDB, ok := getParticipatingConversation(w, req)
+	if !ok {
+		return
+	}
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetMessagesParams{
+		ConversationID: conversationDB.ID,
+		ViewerID:       userID,
+		PageLimit:      p.Limit,
+		PageOffset:     p.Offset,
+	}
+	messagesDB, err := apiCfg.DB.GetMessages(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving messages: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	messages := []Message{}
+	for _, message := range messagesDB {
+		messages = append(messages, mapToMessage(message))
+	}
+	respondWithJSON(w, http.StatusOK, messages)
+}
+
+// handlerMarkConversationRead records that the user has read everything in
+// the conversation so far. Other participants see it as their last_read_at.
+func handlerMarkConversationRead(w http.ResponseWriter, req *http.Request) {
+	userID, conversationDB, ok := getParticipatingConversation(w, req)
+	if !ok {
+		return
+	}
+
+	params := database.MarkConversationReadParams{ConversationID: conversationDB.ID, UserID: userID}
+	err := apiCfg.DB.MarkConversationRead(req.Context(), params)
+	if err != nil {
+		log.Printf("Error marking conversation read: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
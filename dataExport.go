@@ -0,0 +1,436 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/mail"
+)
+
+const dataExportExpiry = time.Hour * 24 * 7
+
+// billingEvents are the audit events that change what a user pays for.
+var billingEvents = []string{auditWebhookUpgrade, auditAdminChirpyRed}
+
+type DataExport struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func mapToDataExport(id uuid.UUID, createdAt time.Time, status string, expiresAt sql.NullTime) DataExport {
+	export := DataExport{ID: id, CreatedAt: createdAt, Status: status}
+	if expiresAt.Valid {
+		export.ExpiresAt = &expiresAt.Time
+	}
+	return export
+}
+
+func handlerRequestDataExport(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	count, err := apiCfg.DB.CountActiveDataExports(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error counting data exports: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		respondWithError(w, http.StatusConflict, "An export is already in progress.")
+		return
+	}
+
+	exportDB, err := apiCfg.DB.CreateDataExport(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error creating data export: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{Type: auditDataExportRequest, ActorID: userID, TargetID: userID})
+	respondWithJSON(w, http.StatusAccepted, mapToDataExport(exportDB.ID, exportDB.CreatedAt, exportDB.Status, exportDB.ExpiresAt))
+}
+
+func handlerGetDataExports(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	exportsDB, err := apiCfg.DB.GetDataExportsByUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving data exports: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	exports := []DataExport{}
+	for _, export := range exportsDB {
+		exports = append(exports, mapToDataExport(export.ID, export.CreatedAt, export.Status, export.ExpiresAt))
+	}
+	respondWithJSON(w, http.StatusOK, exports)
+}
+
+func handlerGetDataExport(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	exportID, err := uuid.Parse(req.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export id")
+		return
+	}
+
+	params := database.GetDataExportParams{ID: exportID, UserID: userID}
+	exportDB, err := apiCfg.DB.GetDataExport(req.Context(), params)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Export not found.")
+			return
+		}
+		log.Printf("Error retreiving data export: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapToDataExport(exportDB.ID, exportDB.CreatedAt, exportDB.Status, exportDB.ExpiresAt))
+}
+
+// handlerDownloadDataExport accepts either the token from the emailed link, so
+// it can be opened in a browser, or the owner's JWT.
+func handlerDownloadDataExport(w http.ResponseWriter, req *http.Request) {
+	exportID, err := uuid.Parse(req.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export id")
+		return
+	}
+
+	archiveDB, err := apiCfg.DB.GetDataExportArchive(req.Context(), exportID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Export not found or has expired.")
+			return
+		}
+		log.Printf("Error retreiving data export: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if token := req.URL.Query().Get("token"); token != "" {
+		hash := auth.HashToken(token)
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(archiveDB.DownloadTokenHash.String)) != 1 {
+			respondWithError(w, http.StatusNotFound, "Export not found or has expired.")
+			return
+		}
+	} else {
+		userID, err := authenticateUser(req, "")
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		if userID != archiveDB.UserID {
+			respondWithError(w, http.StatusNotFound, "Export not found or has expired.")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.zip"`, exportID))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(archiveDB.Archive)
+}
+
+// runDataExportWorker builds pending exports until ctx is done. Exports are
+// claimed with SKIP LOCKED so several server instances can share the work.
+func runDataExportWorker(ctx context.Context) {
+	ticker := time.NewTicker(time.Second * 30)
+	defer ticker.Stop()
+	for {
+		err := apiCfg.DB.ExpireDataExports(ctx)
+		if err != nil {
+			log.Printf("Error expiring data exports: %v\n", err)
+		}
+
+		for {
+			claimed, err := apiCfg.DB.ClaimDataExport(ctx)
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("Error claiming data export: %v\n", err)
+				}
+				break
+			}
+			err = processDataExport(ctx, claimed.ID, claimed.UserID)
+			if err != nil {
+				log.Printf("Error processing data export [%v]: %v\n", claimed.ID, err)
+				err = apiCfg.DB.FailDataExport(ctx, claimed.ID)
+				if err != nil {
+					log.Printf("Error marking data export failed: %v\n", err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func processDataExport(ctx context.Context, exportID, userID uuid.UUID) error {
+	userDB, err := apiCfg.DB.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	archive, err := buildDataExport(ctx, userDB)
+	if err != nil {
+		return err
+	}
+
+	token, _ := auth.MakeRefreshToken() // err is always nil
+	params := database.CompleteDataExportParams{
+		ID:                exportID,
+		Archive:           archive,
+		DownloadTokenHash: sql.NullString{String: auth.HashToken(token), Valid: true},
+		ExpiresAt:         sql.NullTime{Time: time.Now().Add(dataExportExpiry), Valid: true},
+	}
+	err = apiCfg.DB.CompleteDataExport(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/exports/%s/download?token=%s", apiCfg.BaseURL, exportID, url.QueryEscape(token))
+	err = apiCfg.Mailer.Send(mail.Message{
+		To:      userDB.Email,
+		Subject: "Your Chirpy data export is ready",
+		Body:    fmt.Sprintf("The export of your Chirpy data you asked for is ready. Download it from the link below, it expires in 7 days.\n\n%s\n", link),
+	})
+	if err != nil {
+		// The export is still there for the user to download with their token.
+		log.Printf("Error sending data export email: %v\n", err)
+	}
+	return nil
+}
+
+// buildDataExport zips up everything Chirpy holds on the user as JSON files.
+// Secrets like password hashes and token hashes are left out.
+func buildDataExport(ctx context.Context, userDB database.User) ([]byte, error) {
+	files := map[string]any{"profile.json": mapToUser(userDB)}
+
+	chirpsDB, err := apiCfg.DB.GetAllChirpsByUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving chirps: %w", err)
+	}
+	chirps := []Chirp{}
+	for _, chirp := range chirpsDB {
+		chirps = append(chirps, mapToChirp(chirp))
+	}
+	files["chirps.json"] = chirps
+
+	sessionsDB, err := apiCfg.DB.GetSessionsByUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving sessions: %w", err)
+	}
+	sessions := []Session{}
+	for _, session := range sessionsDB {
+		sessions = append(sessions, mapToSession(session))
+	}
+	files["sessions.json"] = sessions
+
+	keysDB, err := apiCfg.DB.GetApiKeysByUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving api keys: %w", err)
+	}
+	keys := []ApiKey{}
+	for _, key := range keysDB {
+		keys = append(keys, mapToApiKey(key))
+	}
+	files["api_keys.json"] = keys
+
+	credentialsDB, err := apiCfg.DB.GetWebauthnCredentialsByUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving passkeys: %w", err)
+	}
+	passkeys := []Passkey{}
+	for _, credential := range credentialsDB {
+		passkeys = append(passkeys, mapToPasskey(credential))
+	}
+	files["passkeys.json"] = passkeys
+
+	identitiesDB, err := apiCfg.DB.GetIdentitiesByUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving identities: %w", err)
+	}
+	type identity struct {
+		CreatedAt time.Time `json:"created_at"`
+		Issuer    string    `json:"issuer"`
+		Subject   string    `json:"subject"`
+		Email     string    `json:"email"`
+	}
+	identities := []identity{}
+	for _, i := range identitiesDB {
+		identities = append(identities, identity{i.CreatedAt, i.Issuer, i.Subject, i.Email})
+	}
+	files["identities.json"] = identities
+
+	messagesDB, err := apiCfg.DB.GetAllMessagesForUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving messages: %w", err)
+	}
+	messages := []Message{}
+	for _, message := range messagesDB {
+		messages = append(messages, mapToMessage(message))
+	}
+	files["messages.json"] = messages
+
+	listsDB, err := apiCfg.DB.GetAllListsByOwner(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving lists: %w", err)
+	}
+	type list struct {
+		List
+		MemberIDs []uuid.UUID `json:"member_ids"`
+	}
+	lists := []list{}
+	for _, l := range listsDB {
+		memberIDs, err := apiCfg.DB.GetListMemberIDs(ctx, l.ID)
+		if err != nil {
+			return nil, fmt.Errorf("retreiving list members: %w", err)
+		}
+		lists = append(lists, list{mapToList(l), append([]uuid.UUID{}, memberIDs...)})
+	}
+	files["lists.json"] = lists
+
+	bookmarksDB, err := apiCfg.DB.GetAllBookmarksByUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving bookmarks: %w", err)
+	}
+	type bookmark struct {
+		ChirpID      uuid.UUID `json:"chirp_id"`
+		BookmarkedAt time.Time `json:"bookmarked_at"`
+	}
+	bookmarks := []bookmark{}
+	for _, b := range bookmarksDB {
+		bookmarks = append(bookmarks, bookmark{b.ChirpID, b.CreatedAt})
+	}
+	files["bookmarks.json"] = bookmarks
+
+	// Blocks and mutes only list the other user's id, not their profile.
+	type relationship struct {
+		UserID    uuid.UUID `json:"user_id"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	blocksDB, err := apiCfg.DB.GetAllBlocksByUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving blocks: %w", err)
+	}
+	blocks := []relationship{}
+	for _, b := range blocksDB {
+		blocks = append(blocks, relationship{b.BlockedID, b.CreatedAt})
+	}
+	files["blocks.json"] = blocks
+
+	mutesDB, err := apiCfg.DB.GetAllMutesByUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving mutes: %w", err)
+	}
+	mutes := []relationship{}
+	for _, m := range mutesDB {
+		mutes = append(mutes, relationship{m.MutedID, m.CreatedAt})
+	}
+	files["mutes.json"] = mutes
+
+	consentsDB, err := apiCfg.DB.GetOAuthConsentsByUser(ctx, userDB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retreiving oauth consents: %w", err)
+	}
+	consents := []OAuthConsent{}
+	for _, consent := range consentsDB {
+		consents = append(consents, OAuthConsent{
+			ClientID:   consent.ClientID,
+			ClientName: consent.ClientName,
+			Scopes:     consent.Scopes,
+			CreatedAt:  consent.CreatedAt,
+			UpdatedAt:  consent.UpdatedAt,
+		})
+	}
+	files["oauth_consents.json"] = consents
+
+	eventsDB, err := apiCfg.DB.GetAuditEventsForUser(ctx, uuid.NullUUID{UUID: userDB.ID, Valid: true})
+	if err != nil {
+		return nil, fmt.Errorf("retreiving audit events: %w", err)
+	}
+	billing, security := []AuditEvent{}, []AuditEvent{}
+	for _, event := range eventsDB {
+		if slices.Contains(billingEvents, event.EventType) {
+			billing = append(billing, mapToAuditEvent(event))
+		} else {
+			security = append(security, mapToAuditEvent(event))
+		}
+	}
+	files["billing_events.json"] = billing
+	files["security_events.json"] = security
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(data)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %v: %w", name, err)
+		}
+	}
+
+	// The avatar is added as the image itself, e.g. avatar.png.
+	avatarDB, err := apiCfg.DB.GetAvatar(ctx, userDB.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("retreiving avatar: %w", err)
+	}
+	if err == nil {
+		f, err := zw.Create("avatar." + strings.TrimPrefix(avatarDB.ContentType, "image/"))
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write(avatarDB.Image)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
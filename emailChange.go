@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/mail"
+)
+
+const emailChangeExpiry = time.Hour * 24
+
+// createEmailChangeToken replaces any pending change with one to newEmail and
+// returns its token. The email isn't changed until the link is followed. Send
+// it with sendEmailChangeEmails once the transaction this runs in commits.
+func createEmailChangeToken(ctx context.Context, q *database.Queries, userID uuid.UUID, newEmail string) (string, error) {
+	err := q.CancelEmailChanges(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	token, _ := auth.MakeRefreshToken() // err is always nil
+	params := database.CreateEmailChangeTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userID,
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(emailChangeExpiry),
+	}
+	err = q.CreateEmailChangeToken(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendEmailChangeEmails sends the confirmation link to the new address and
+// tells the old address about the change. Failures are logged, the user can
+// ask for the change again.
+func sendEmailChangeEmails(user database.User, newEmail, token string) {
+	link := fmt.Sprintf("%s/api/email/change/confirm?token=%s", apiCfg.BaseURL, url.QueryEscape(token))
+	err := apiCfg.Mailer.Send(mail.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Chirpy email address",
+		Body:    fmt.Sprintf("Confirm this is the new email address for your Chirpy account by visiting the link below. It expires in 24 hours.\n\n%s\n", link),
+	})
+	if err != nil {
+		log.Printf("Error sending email change confirmation: %v\n", err)
+		return
+	}
+
+	err = apiCfg.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy email address is being changed",
+		Body:    fmt.Sprintf("Someone asked to change the email address on your Chirpy account to %s. It won't change until the new address is confirmed.\n\nIf this wasn't you, change your password straight away.\n", newEmail),
+	})
+	if err != nil {
+		log.Printf("Error sending email change notice: %v\n", err)
+	}
+}
+
+func handlerConfirmEmailChange(w http.ResponseWriter, req *http.Request) {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing confirmation token")
+		return
+	}
+
+	// The token is only used up if the email is actually changed.
+	var change database.UseEmailChangeTokenRow
+	var previous database.User
+	err := withTx(req.Context(), func(q *database.Queries) error {
+		var err error
+		change, err = q.UseEmailChangeToken(req.Context(), auth.HashToken(token))
+		if err != nil {
+			return err
+		}
+		previous, err = q.GetUserByID(req.Context(), change.UserID)
+		if err != nil {
+			return fmt.Errorf("retreiving user: %w", err)
+		}
+		params := database.UpdateUserEmailParams{ID: change.UserID, Email: change.NewEmail}
+		_, err = q.UpdateUserEmail(req.Context(), params)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Confirmation link is invalid or has expired.")
+			return
+		}
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "That email address is already in use.")
+			return
+		}
+		log.Printf("Error changing user email: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{
+		Type:     auditEmailChange,
+		ActorID:  change.UserID,
+		TargetID: change.UserID,
+		Details:  map[string]any{"old_email": previous.Email, "new_email": change.NewEmail},
+	})
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Email address changed."))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/mail"
+)
+
+const emailVerificationExpiry = time.Hour * 24
+
+func sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, _ := auth.MakeRefreshToken() // err is always nil
+	params := database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(emailVerificationExpiry),
+	}
+	err := apiCfg.DB.CreateEmailVerificationToken(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/email/verify?token=%s", apiCfg.BaseURL, url.QueryEscape(token))
+	return apiCfg.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body:    fmt.Sprintf("Welcome to Chirpy!\n\nConfirm your email address by visiting the link below. It expires in 24 hours.\n\n%s\n", link),
+	})
+}
+
+func handlerVerifyEmail(w http.ResponseWriter, req *http.Request) {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing verification token")
+		return
+	}
+
+	userID, err := apiCfg.DB.UseEmailVerificationToken(req.Context(), auth.HashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Verification link is invalid or has expired.")
+			return
+		}
+		log.Printf("Error using verification token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = apiCfg.DB.VerifyUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error verifying user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Email verified."))
+}
+
+func handlerResendVerificationEmail(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if userDB.IsVerified {
+		respondWithError(w, http.StatusBadRequest, "Email is already verified.")
+		return
+	}
+
+	err = sendVerificationEmail(req.Context(), userDB)
+	if err != nil {
+		log.Printf("Error sending verification email: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
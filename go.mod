@@ -11,4 +11,6 @@ require (
 
 require github.com/golang-jwt/jwt/v5 v5.2.1
 
+require golang.org/x/sys v0.31.0 // indirect
+
 replace chirpy/internal/auth => ../internal/auth
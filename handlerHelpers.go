@@ -1,11 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
 )
 
+var errMissingScope = errors.New("credentials are missing the required scope")
+
 func respondWithError(w http.ResponseWriter, code int, msg string) {
 	type responseError struct {
 		Error string `json:"error"`
@@ -13,6 +28,25 @@ func respondWithError(w http.ResponseWriter, code int, msg string) {
 	respondWithJSON(w, code, responseError{msg})
 }
 
+// checkPassword responds with the policy violations and returns false if the password isn't acceptable.
+func checkPassword(w http.ResponseWriter, password, email string) bool {
+	violations := apiCfg.PasswordPolicy.Check(password, email)
+	if len(violations) == 0 {
+		return true
+	}
+	respondWithJSON(w, http.StatusBadRequest, struct {
+		Error      string                 `json:"error"`
+		Violations []auth.PolicyViolation `json:"violations"`
+	}{"Password doesn't meet the password policy", violations})
+	return false
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a duplicate value.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -23,3 +57,148 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(data)
 }
+
+// authenticateUser returns the user behind the request's Authorization header.
+// A JWT grants full access, API keys and third party access tokens are only
+// accepted if they carry scope. Pass an empty scope for endpoints that shouldn't
+// be reachable with either. Suspended and banned users are always rejected.
+func authenticateUser(req *http.Request, scope string) (uuid.UUID, error) {
+	userID, err := authenticateCredentials(req, scope)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("retreiving user: %w", err)
+	}
+	err = checkAccountStatus(userDB)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return userID, nil
+}
+
+func authenticateCredentials(req *http.Request, scope string) (uuid.UUID, error) {
+	if token, err := auth.GetBearerToken(req.Header); err == nil {
+		userID, err := auth.ValidateJWT(token, apiCfg.TokenSecret)
+		if err == nil {
+			return userID, nil
+		}
+		access, oauthErr := auth.ValidateOAuthAccessToken(token, apiCfg.TokenSecret)
+		if oauthErr != nil {
+			return uuid.UUID{}, err
+		}
+		err = checkOAuthConsent(req.Context(), access)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+		if scope == "" {
+			return uuid.UUID{}, errors.New("endpoint doesn't accept third party access tokens")
+		}
+		if !slices.Contains(access.Scopes, scope) {
+			return uuid.UUID{}, fmt.Errorf("%w [%v]", errMissingScope, scope)
+		}
+		return access.UserID, nil
+	}
+
+	key, err := auth.GetApiKey(req.Header)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	if scope == "" {
+		return uuid.UUID{}, errors.New("endpoint doesn't accept api keys")
+	}
+
+	keyDB, err := apiCfg.DB.GetApiKeyByHash(req.Context(), auth.HashToken(key))
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("invalid api key: %w", err)
+	}
+	if !slices.Contains(keyDB.Scopes, scope) {
+		return uuid.UUID{}, fmt.Errorf("%w [%v]", errMissingScope, scope)
+	}
+
+	err = apiCfg.DB.TouchApiKey(req.Context(), keyDB.ID)
+	if err != nil {
+		log.Printf("Error updating api key last used time: %v\n", err)
+	}
+	return keyDB.UserID, nil
+}
+
+// viewerID is the signed in user on endpoints that don't need one, so what
+// they've blocked or muted can be hidden. Anything but a valid JWT is anonymous.
+func viewerID(req *http.Request) uuid.NullUUID {
+	token, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	userID, err := auth.ValidateJWT(token, apiCfg.TokenSecret)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: userID, Valid: true}
+}
+
+// withTx runs fn in a transaction, which is committed if fn returns nil and
+// rolled back otherwise.
+func withTx(ctx context.Context, fn func(q *database.Queries) error) error {
+	tx, err := apiCfg.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(apiCfg.DB.WithTx(tx))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	log.Printf("Error authenticating request: %v\n", err)
+	var restricted *accountRestrictedError
+	if errors.As(err, &restricted) {
+		respondWithAccountRestricted(w, restricted)
+		return
+	}
+	if errors.Is(err, errMissingScope) {
+		respondWithError(w, http.StatusForbidden, "Credentials don't have permission for this action")
+		return
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+type page struct {
+	Limit  int32
+	Offset int32
+}
+
+// parsePage reads the limit and offset query parameters, limit defaults to 50 and can be at most 100.
+func parsePage(req *http.Request) (page, error) {
+	p := page{Limit: 50}
+	query := req.URL.Query()
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > 100 {
+			return page{}, errors.New("limit must be between 1 and 100")
+		}
+		p.Limit = int32(limit)
+	}
+	if raw := query.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 || offset > math.MaxInt32 {
+			return page{}, errors.New("offset must be a positive integer")
+		}
+		p.Offset = int32(offset)
+	}
+	return p, nil
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
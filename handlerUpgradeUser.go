@@ -54,6 +54,11 @@ func handlerPaymentWebhook(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	recordAuditEvent(req, auditEvent{
+		Type:     auditWebhookUpgrade,
+		TargetID: id,
+		Details:  map[string]any{"event": body.Event},
+	})
 	log.Printf("Successfully upgraded user [%v] to red \n", id)
 	w.WriteHeader(http.StatusNoContent)
 }
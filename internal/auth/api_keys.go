@@ -1,11 +1,16 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strings"
 )
 
+// ApiKeyPrefix marks user generated API keys so they're easy to spot in logs and secret scanners.
+const ApiKeyPrefix = "chirpy_"
+
 func GetApiKey(headers http.Header) (string, error) {
 	for _, token := range headers.Values("Authorization") {
 		if strings.Contains(token, "ApiKey ") {
@@ -14,3 +19,12 @@ func GetApiKey(headers http.Header) (string, error) {
 	}
 	return "", errors.New("authorization header doesn't exist")
 }
+
+func MakeApiKey() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return ApiKeyPrefix + hex.EncodeToString(b), nil
+}
@@ -10,26 +10,97 @@ import (
 	"github.com/google/uuid"
 )
 
-func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
+const (
+	accessTokenIssuer = "chirpy"
+	// MFA challenge tokens only prove the password was correct, so they use a
+	// different issuer to stop them being accepted as access tokens.
+	mfaTokenIssuer = "chirpy-mfa"
+	mfaTokenExpiry = time.Minute * 5
+	// Stream tokens go in the URL of an EventSource, which can't send headers.
+	// They only open the event stream, and only while the session they were
+	// made from is active. They last as long as events are kept for replay.
+	streamTokenIssuer = "chirpy-stream"
+	StreamTokenExpiry = time.Hour * 24
+)
+
+func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeToken(userID, tokenSecret, accessTokenIssuer, expiresIn)
+}
+
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return validateToken(tokenString, tokenSecret, accessTokenIssuer)
+}
+
+func MakeMFAToken(userID uuid.UUID, tokenSecret string) (string, error) {
+	return makeToken(userID, tokenSecret, mfaTokenIssuer, mfaTokenExpiry)
+}
+
+func ValidateMFAToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return validateToken(tokenString, tokenSecret, mfaTokenIssuer)
+}
+
+// StreamToken identifies the session by a hash of its refresh token, so the
+// refresh token itself never ends up in a URL.
+type StreamToken struct {
+	UserID      uuid.UUID
+	SessionHash string
+}
+
+func MakeStreamToken(userID uuid.UUID, sessionHash, tokenSecret string) (string, error) {
 	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
+		Issuer:    streamTokenIssuer,
 		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(StreamTokenExpiry)),
 		Subject:   userID.String(),
+		ID:        sessionHash,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(tokenSecret))
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+func ValidateStreamToken(tokenString, tokenSecret string) (StreamToken, error) {
 	var claims jwt.RegisteredClaims
 	keyFunc := func(token *jwt.Token) (any, error) {
 		return []byte(tokenSecret), nil
 	}
-	token, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc)
+	_, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc, jwt.WithIssuer(streamTokenIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return StreamToken{}, err
+	}
+	if claims.ID == "" {
+		return StreamToken{}, errors.New("stream token has no session")
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return StreamToken{}, err
+	}
+	return StreamToken{UserID: userID, SessionHash: claims.ID}, nil
+}
+
+func makeToken(userID uuid.UUID, tokenSecret, issuer string, expiresIn time.Duration) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Issuer:    issuer,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		Subject:   userID.String(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+func validateToken(tokenString, tokenSecret, issuer string) (uuid.UUID, error) {
+	var claims jwt.RegisteredClaims
+	keyFunc := func(token *jwt.Token) (any, error) {
+		return []byte(tokenSecret), nil
+	}
+	token, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc, jwt.WithIssuer(issuer))
 	if err != nil {
 		return uuid.UUID{}, err
 	}
+	if len(claims.Audience) > 0 {
+		return uuid.UUID{}, errors.New("token was issued to a third party client")
+	}
 
 	user, err := token.Claims.GetSubject()
 	if err != nil {
@@ -38,6 +109,65 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	return uuid.Parse(user)
 }
 
+// OAuthAccessToken is a token issued to a third party client. It's limited to
+// the scopes the user consented to, and is never accepted by ValidateJWT.
+type OAuthAccessToken struct {
+	UserID    uuid.UUID
+	ClientID  string
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+type oauthClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope"`
+}
+
+func MakeOAuthAccessToken(userID uuid.UUID, clientID string, scopes []string, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims := oauthClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    accessTokenIssuer,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{clientID},
+		},
+		Scope: strings.Join(scopes, " "),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+func ValidateOAuthAccessToken(tokenString, tokenSecret string) (OAuthAccessToken, error) {
+	var claims oauthClaims
+	keyFunc := func(token *jwt.Token) (any, error) {
+		return []byte(tokenSecret), nil
+	}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc, jwt.WithIssuer(accessTokenIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return OAuthAccessToken{}, err
+	}
+	if len(claims.Audience) != 1 {
+		return OAuthAccessToken{}, errors.New("token wasn't issued to a third party client")
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return OAuthAccessToken{}, err
+	}
+	access := OAuthAccessToken{
+		UserID:    userID,
+		ClientID:  claims.Audience[0],
+		Scopes:    strings.Fields(claims.Scope),
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if claims.IssuedAt != nil {
+		access.IssuedAt = claims.IssuedAt.Time
+	}
+	return access, nil
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
 	for _, token := range headers.Values("Authorization") {
 		if strings.Contains(token, "Bearer ") {
@@ -48,6 +48,9 @@ func TestValidateJWT(t *testing.T) {
 	duration, _ := time.ParseDuration("1m")
 	tokenSecret := "test"
 	tokenString, _ := MakeJWT(id, tokenSecret, duration)
+	mfaTokenString, _ := MakeMFAToken(id, tokenSecret)
+	streamTokenString, _ := MakeStreamToken(id, HashToken("refresh"), tokenSecret)
+	oauthTokenString, _ := MakeOAuthAccessToken(id, "client", []string{"chirps:write"}, tokenSecret, duration)
 
 	type args struct {
 		tokenString string
@@ -68,6 +71,33 @@ func TestValidateJWT(t *testing.T) {
 			want:    id,
 			wantErr: false,
 		},
+		{
+			name: "Rejects MFA challenge token",
+			args: args{
+				tokenString: mfaTokenString,
+				tokenSecret: tokenSecret,
+			},
+			want:    uuid.UUID{},
+			wantErr: true,
+		},
+		{
+			name: "Rejects stream token",
+			args: args{
+				tokenString: streamTokenString,
+				tokenSecret: tokenSecret,
+			},
+			want:    uuid.UUID{},
+			wantErr: true,
+		},
+		{
+			name: "Rejects third party access token",
+			args: args{
+				tokenString: oauthTokenString,
+				tokenSecret: tokenSecret,
+			},
+			want:    uuid.UUID{},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -83,6 +113,47 @@ func TestValidateJWT(t *testing.T) {
 	}
 }
 
+func TestValidateOAuthAccessToken(t *testing.T) {
+	id, _ := uuid.NewUUID()
+	tokenSecret := "test"
+	tokenString, _ := MakeOAuthAccessToken(id, "client", []string{"chirps:write", "chirps:delete"}, tokenSecret, time.Minute)
+	firstPartyString, _ := MakeJWT(id, tokenSecret, time.Minute)
+
+	tests := []struct {
+		name        string
+		tokenString string
+		wantScopes  []string
+		wantErr     bool
+	}{
+		{
+			name:        "Basic validate access token test",
+			tokenString: tokenString,
+			wantScopes:  []string{"chirps:write", "chirps:delete"},
+			wantErr:     false,
+		},
+		{
+			name:        "Rejects first party token",
+			tokenString: firstPartyString,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateOAuthAccessToken(tt.tokenString, tokenSecret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateOAuthAccessToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.UserID != id || got.ClientID != "client" || !reflect.DeepEqual(got.Scopes, tt.wantScopes) || got.IssuedAt.IsZero() {
+				t.Errorf("ValidateOAuthAccessToken() = %+v", got)
+			}
+		})
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	type args struct {
 		headers http.Header
@@ -123,3 +194,57 @@ func TestGetBearerToken(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateStreamToken(t *testing.T) {
+	id, _ := uuid.NewUUID()
+	tokenSecret := "test"
+	sessionHash := HashToken("refresh")
+	tokenString, _ := MakeStreamToken(id, sessionHash, tokenSecret)
+	accessTokenString, _ := MakeJWT(id, tokenSecret, time.Minute)
+	noSessionString, _ := MakeStreamToken(id, "", tokenSecret)
+
+	tests := []struct {
+		name        string
+		tokenString string
+		tokenSecret string
+		want        StreamToken
+		wantErr     bool
+	}{
+		{
+			name:        "Basic validate stream token test",
+			tokenString: tokenString,
+			tokenSecret: tokenSecret,
+			want:        StreamToken{UserID: id, SessionHash: sessionHash},
+		},
+		{
+			name:        "Rejects access token",
+			tokenString: accessTokenString,
+			tokenSecret: tokenSecret,
+			wantErr:     true,
+		},
+		{
+			name:        "Rejects token without a session",
+			tokenString: noSessionString,
+			tokenSecret: tokenSecret,
+			wantErr:     true,
+		},
+		{
+			name:        "Rejects wrong secret",
+			tokenString: tokenString,
+			tokenSecret: "other",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateStreamToken(tt.tokenString, tt.tokenSecret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateStreamToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ValidateStreamToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
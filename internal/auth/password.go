@@ -1,12 +1,114 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrPasswordMismatch = errors.New("password doesn't match hash")
+
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// PasswordParams are used for new hashes. Hashes made with other parameters
+// still verify, NeedsRehash reports them so they can be upgraded.
+var PasswordParams = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// HashPassword returns an argon2id hash in PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
 func HashPassword(password string) (string, error) {
-	pass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(pass), err
+	p := PasswordParams
+	salt := make([]byte, p.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
+// CheckPasswordHash verifies argon2id hashes and bcrypt hashes made before argon2id was introduced.
 func CheckPasswordHash(password, hashstring string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashstring), []byte(password))
+	if !strings.HasPrefix(hashstring, "$argon2id$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hashstring), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+
+	p, salt, key, err := decodeArgon2Hash(hashstring)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether a hash uses a different algorithm or parameters than PasswordParams.
+func NeedsRehash(hashstring string) bool {
+	p, _, _, err := decodeArgon2Hash(hashstring)
+	return err != nil || p != PasswordParams
+}
+
+func decodeArgon2Hash(hashstring string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hashstring, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("parsing argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	var p Argon2Params
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("parsing argon2id parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("decoding argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("decoding argon2id hash: %w", err)
+	}
+	if p.Iterations == 0 || p.Parallelism == 0 || len(key) == 0 {
+		return Argon2Params{}, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
 }
@@ -1,9 +1,17 @@
 package auth
 
 import (
+	"strings"
 	"testing"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
+func legacyHash(password string) string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	return string(hash)
+}
+
 func TestHashPassword(t *testing.T) {
 	type args struct {
 		password string
@@ -54,6 +62,52 @@ func TestCheckPasswordHash(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Rejects wrong password",
+			args: args{
+				password: "test",
+				hashstring: func() string {
+					p, _ := HashPassword("Test")
+					return p
+				}(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Long passwords aren't truncated",
+			args: args{
+				password: strings.Repeat("a", 80) + "b",
+				hashstring: func() string {
+					p, _ := HashPassword(strings.Repeat("a", 80) + "c")
+					return p
+				}(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Verifies legacy bcrypt hash",
+			args: args{
+				password:   "Test",
+				hashstring: legacyHash("Test"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "Rejects wrong password for legacy bcrypt hash",
+			args: args{
+				password:   "test",
+				hashstring: legacyHash("Test"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Rejects malformed hash",
+			args: args{
+				password:   "Test",
+				hashstring: "$argon2id$v=19$m=65536$salt$hash",
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -63,3 +117,47 @@ func TestCheckPasswordHash(t *testing.T) {
 		})
 	}
 }
+
+func TestNeedsRehash(t *testing.T) {
+	current, _ := HashPassword("Test")
+	weaker := PasswordParams
+	weaker.Iterations = 1
+	old := func() string {
+		defer func(p Argon2Params) { PasswordParams = p }(PasswordParams)
+		PasswordParams = weaker
+		p, _ := HashPassword("Test")
+		return p
+	}()
+
+	tests := []struct {
+		name       string
+		hashstring string
+		want       bool
+	}{
+		{
+			name:       "Current parameters",
+			hashstring: current,
+			want:       false,
+		},
+		{
+			name:       "Old parameters",
+			hashstring: old,
+			want:       true,
+		},
+		{
+			name:       "Legacy bcrypt hash",
+			hashstring: legacyHash("Test"),
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NeedsRehash(tt.hashstring); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+			if err := CheckPasswordHash("Test", tt.hashstring); err != nil {
+				t.Errorf("CheckPasswordHash() error = %v", err)
+			}
+		})
+	}
+}
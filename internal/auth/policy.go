@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+type PasswordPolicy struct {
+	MinLength  int
+	MinEntropy float64 // bits, see EstimateEntropy
+	Breached   *BreachedPasswords
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:  8,
+	MinEntropy: 36,
+}
+
+type PolicyViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Check returns every rule the password breaks, or nil if it's acceptable.
+func (p PasswordPolicy) Check(password, email string) []PolicyViolation {
+	var violations []PolicyViolation
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, PolicyViolation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters.", p.MinLength),
+		})
+	}
+	if EstimateEntropy(password) < p.MinEntropy {
+		violations = append(violations, PolicyViolation{
+			Code:    "too_predictable",
+			Message: "Password is too easy to guess, try a longer password or a mix of character types.",
+		})
+	}
+	if similarToEmail(password, email) {
+		violations = append(violations, PolicyViolation{
+			Code:    "similar_to_email",
+			Message: "Password can't contain your email address.",
+		})
+	}
+	if p.Breached.Contains(password) {
+		violations = append(violations, PolicyViolation{
+			Code:    "breached",
+			Message: "Password has appeared in a data breach, choose a different one.",
+		})
+	}
+	return violations
+}
+
+// EstimateEntropy is a rough estimate in bits based on the character classes
+// used. Repeated characters and runs like "abc" or "321" don't add any.
+func EstimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	effective := 0
+	var prev rune
+	for i, r := range []rune(password) {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsLower(r):
+			lower = true
+		case r < unicode.MaxASCII && unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+		if i == 0 || (r != prev && r != prev+1 && r != prev-1) {
+			effective++
+		}
+		prev = r
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(effective) * math.Log2(float64(pool))
+}
+
+// similarToEmail catches passwords built from the email's local part, like "jane.doe1990".
+func similarToEmail(password, email string) bool {
+	local, _, _ := strings.Cut(email, "@")
+	password, local = alphanumeric(password), alphanumeric(local)
+	if len(local) < 3 || password == "" {
+		return false
+	}
+	return strings.Contains(password, local) || strings.Contains(local, password)
+}
+
+func alphanumeric(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// BreachedPasswords looks passwords up in a file of upper case SHA-1 hashes,
+// one per line and sorted by hash, like the Pwned Passwords "ordered by hash"
+// download. Lines may have a ":count" suffix. The file is binary searched on
+// disk rather than loaded, so it can be the full corpus.
+type BreachedPasswords struct {
+	f    *os.File
+	size int64
+}
+
+// breachedSortCheckLines is how many lines are checked for order when the file
+// is opened. Checking all of a full corpus would take minutes, this catches
+// the usual mistake of downloading the list ordered by count instead.
+const breachedSortCheckLines = 1000
+
+func OpenBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(f)
+	previous := ""
+	for i := 0; i < breachedSortCheckLines && scanner.Scan(); i++ {
+		hash := breachedLineHash(scanner.Text())
+		if hash < previous {
+			f.Close()
+			return nil, errors.New("breached passwords file must be sorted by hash")
+		}
+		previous = hash
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading breached passwords: %w", err)
+	}
+	return &BreachedPasswords{f: f, size: info.Size()}, nil
+}
+
+func breachedLineHash(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return hash
+}
+
+// lineFrom returns the hash on the first line starting at or after offset,
+// or "" at the end of the file.
+func (b *BreachedPasswords) lineFrom(offset int64) (string, error) {
+	start := max(offset-1, 0)
+	r := bufio.NewReaderSize(io.NewSectionReader(b.f, start, b.size-start), 128)
+	if offset > 0 {
+		// Skip the rest of the line offset is in, unless it's the start of one.
+		_, err := r.ReadString('\n')
+		if err != nil {
+			return "", nil
+		}
+	}
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return breachedLineHash(line), nil
+}
+
+// Contains reports whether the password is in the file. If the file can't be
+// read the password is allowed, the other rules still apply.
+func (b *BreachedPasswords) Contains(password string) bool {
+	if b == nil {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// Find the first line with a hash at least as large.
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, err := b.lineFrom(mid)
+		if err != nil {
+			return false
+		}
+		if line == "" || line >= hash {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	line, err := b.lineFrom(lo)
+	return err == nil && line == hash
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// SHA-1 of "correct horse battery staple"
+	err := os.WriteFile(path, []byte("ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42:42\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breached, err := OpenBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := DefaultPasswordPolicy
+	policy.Breached = breached
+
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		want     []string
+	}{
+		{
+			name:     "Strong password",
+			password: "Tr0ub4dor&3-Zebra",
+			email:    "jane@example.com",
+			want:     nil,
+		},
+		{
+			name:     "Too short",
+			password: "xQ7#",
+			email:    "jane@example.com",
+			want:     []string{"too_short", "too_predictable"},
+		},
+		{
+			name:     "Predictable sequence",
+			password: "abcdefghijkl",
+			email:    "jane@example.com",
+			want:     []string{"too_predictable"},
+		},
+		{
+			name:     "Contains email",
+			password: "Jane.Doe#2024!",
+			email:    "jane.doe@example.com",
+			want:     []string{"similar_to_email"},
+		},
+		{
+			name:     "Breached password",
+			password: "correct horse battery staple",
+			email:    "jane@example.com",
+			want:     []string{"breached"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range policy.Check(tt.password, tt.email) {
+				got = append(got, v.Code)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreachedPasswordsContains(t *testing.T) {
+	passwords := map[string]string{} // hash to password
+	var hashes []string
+	for i := range 500 {
+		password := fmt.Sprintf("breached-%d", i)
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		passwords[hash] = password
+		hashes = append(hashes, hash)
+	}
+	slices.Sort(hashes)
+	var file strings.Builder
+	for i, hash := range hashes {
+		// Counts vary the line lengths, and the downloads use CRLF.
+		fmt.Fprintf(&file, "%s:%d\r\n", hash, i*37)
+	}
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte(file.String()), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breached, err := OpenBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"First line", passwords[hashes[0]], true},
+		{"Middle line", passwords[hashes[250]], true},
+		{"Last line", passwords[hashes[len(hashes)-1]], true},
+		{"Not breached", "breached-500", false},
+		{"Empty password", "", false},
+		{"Strong password", "Tr0ub4dor&3-Zebra", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := breached.Contains(tt.password); got != tt.want {
+				t.Errorf("Contains(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenBreachedPasswordsUnsorted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte("BBF7AAD6438836DBE526AA231ABDE2D0EEF74D42:9\nABF7AAD6438836DBE526AA231ABDE2D0EEF74D42:42\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenBreachedPasswords(path)
+	if err == nil {
+		t.Error("OpenBreachedPasswords() accepted a file that isn't sorted")
+	}
+}
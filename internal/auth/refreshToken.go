@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 )
 
@@ -11,3 +12,10 @@ func MakeRefreshToken() (string, error) {
 	s := hex.EncodeToString(b)
 	return s, nil
 }
+
+// HashToken is used to store high entropy secrets (API keys, single use tokens) at rest.
+// A fast hash is fine here as the inputs can't be brute forced, and it keeps lookups indexable.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// LoginPolicy decides how long logins are paused after repeated failures.
+type LoginPolicy struct {
+	BackoffAfter    int32         // failures allowed before delays start
+	LockoutAfter    int32         // failures before a full lockout
+	MaxBackoff      time.Duration // longest delay before a lockout
+	LockoutDuration time.Duration
+}
+
+// Delay doubles from one second with each failure past BackoffAfter, up to
+// MaxBackoff. It reports whether the failures have reached a full lockout.
+func (p LoginPolicy) Delay(failures int32) (time.Duration, bool) {
+	if failures >= p.LockoutAfter {
+		return p.LockoutDuration, true
+	}
+	if failures < p.BackoffAfter {
+		return 0, false
+	}
+	// Past 2^30 seconds the delay would overflow, and be well past any
+	// sensible MaxBackoff anyway.
+	doublings := failures - p.BackoffAfter
+	if doublings > 30 {
+		return p.MaxBackoff, false
+	}
+	return min(time.Second<<doublings, p.MaxBackoff), false
+}
+
+// RateLimiter is a sliding window limiter kept in memory, so limits apply per server instance.
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+	}
+}
+
+func (rl *RateLimiter) Allow(key string) bool {
+	return rl.allowAt(key, time.Now())
+}
+
+func (rl *RateLimiter) allowAt(key string, now time.Time) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if len(rl.hits) > 10000 {
+		for k, hits := range rl.hits {
+			if len(hits) == 0 || now.Sub(hits[len(hits)-1]) > rl.window {
+				delete(rl.hits, k)
+			}
+		}
+	}
+
+	hits := rl.hits[key]
+	for len(hits) > 0 && now.Sub(hits[0]) > rl.window {
+		hits = hits[1:]
+	}
+	if len(hits) >= rl.limit {
+		rl.hits[key] = hits
+		return false
+	}
+	rl.hits[key] = append(hits, now)
+	return true
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+	policy := LoginPolicy{BackoffAfter: 3, LockoutAfter: 10, MaxBackoff: time.Minute, LockoutDuration: time.Minute * 30}
+	wide := LoginPolicy{BackoffAfter: 20, LockoutAfter: 100, MaxBackoff: time.Minute * 5, LockoutDuration: time.Hour}
+
+	tests := []struct {
+		name       string
+		policy     LoginPolicy
+		failures   int32
+		wantDelay  time.Duration
+		wantLocked bool
+	}{
+		{"No failures", policy, 0, 0, false},
+		{"Below backoff", policy, 2, 0, false},
+		{"First backoff", policy, 3, time.Second, false},
+		{"Doubles", policy, 5, time.Second * 4, false},
+		{"Capped at max backoff", policy, 9, time.Minute, false},
+		{"Lockout", policy, 10, time.Minute * 30, true},
+		{"Past lockout", policy, 25, time.Minute * 30, true},
+		{"No overflow with many doublings", wide, 99, time.Minute * 5, false},
+		{"Last delay before overflow", wide, 50, time.Minute * 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, locked := tt.policy.Delay(tt.failures)
+			if delay != tt.wantDelay || locked != tt.wantLocked {
+				t.Errorf("Delay(%d) = %v, %v, want %v, %v", tt.failures, delay, locked, tt.wantDelay, tt.wantLocked)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type hit struct {
+		key    string
+		offset time.Duration
+		want   bool
+	}
+	tests := []struct {
+		name string
+		hits []hit
+	}{
+		{
+			name: "Allows up to the limit",
+			hits: []hit{{"a", 0, true}, {"a", time.Second, true}, {"a", time.Second * 2, true}, {"a", time.Second * 3, false}},
+		},
+		{
+			name: "Keys are limited separately",
+			hits: []hit{{"a", 0, true}, {"a", 0, true}, {"a", 0, true}, {"b", 0, true}, {"a", 0, false}},
+		},
+		{
+			name: "Window slides",
+			hits: []hit{
+				{"a", 0, true},
+				{"a", time.Second * 30, true},
+				{"a", time.Second * 40, true},
+				{"a", time.Second * 50, false},
+				// The first hit has left the window, the others haven't.
+				{"a", time.Second * 61, true},
+				{"a", time.Second * 62, false},
+			},
+		},
+		{
+			name: "Refused hits don't count",
+			hits: []hit{
+				{"a", 0, true},
+				{"a", 0, true},
+				{"a", 0, true},
+				{"a", time.Second * 30, false},
+				{"a", time.Second * 61, true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewRateLimiter(3, time.Minute)
+			for i, h := range tt.hits {
+				got := rl.allowAt(h.key, start.Add(h.offset))
+				if got != h.want {
+					t.Errorf("hit %d: allowAt(%v, +%v) = %v, want %v", i, h.key, h.offset, got, h.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// Test vectors from RFC 6238, truncated to 6 digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	type args struct {
+		secret string
+		code   string
+		t      time.Time
+	}
+	tests := []struct {
+		name   string
+		args   args
+		want   int64
+		wantOK bool
+	}{
+		{
+			name:   "RFC 6238 vector at 59 seconds",
+			args:   args{secret: secret, code: "287082", t: time.Unix(59, 0)},
+			want:   1,
+			wantOK: true,
+		},
+		{
+			name:   "RFC 6238 vector at 1111111109 seconds",
+			args:   args{secret: secret, code: "081804", t: time.Unix(1111111109, 0)},
+			want:   37037036,
+			wantOK: true,
+		},
+		{
+			name:   "RFC 6238 vector at 2000000000 seconds",
+			args:   args{secret: secret, code: "279037", t: time.Unix(2000000000, 0)},
+			want:   66666666,
+			wantOK: true,
+		},
+		{
+			name:   "Accepts code from the previous period",
+			args:   args{secret: secret, code: "287082", t: time.Unix(89, 0)},
+			want:   1,
+			wantOK: true,
+		},
+		{
+			name:   "Rejects code from two periods ago",
+			args:   args{secret: secret, code: "287082", t: time.Unix(119, 0)},
+			wantOK: false,
+		},
+		{
+			name:   "Rejects wrong code",
+			args:   args{secret: secret, code: "123456", t: time.Unix(59, 0)},
+			wantOK: false,
+		},
+		{
+			name:   "Rejects invalid secret",
+			args:   args{secret: "not base32!", code: "287082", t: time.Unix(59, 0)},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(tt.args.secret, tt.args.code, tt.args.t)
+			if ok != tt.wantOK {
+				t.Errorf("ValidateTOTP() ok = %v, wantOK %v", ok, tt.wantOK)
+				return
+			}
+			if ok && got != tt.want {
+				t.Errorf("ValidateTOTP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeRecoveryCodes(t *testing.T) {
+	codes, err := MakeRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("MakeRecoveryCodes() error = %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 19 {
+			t.Errorf("MakeRecoveryCodes() code = %v, want 19 characters", code)
+		}
+		normalized := NormalizeRecoveryCode(code)
+		if seen[normalized] {
+			t.Errorf("MakeRecoveryCodes() returned duplicate code %v", code)
+		}
+		seen[normalized] = true
+	}
+}
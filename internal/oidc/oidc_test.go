@@ -0,0 +1,153 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a minimal OpenID Connect provider that issues a token for a single user.
+type mockProvider struct {
+	server        *httptest.Server
+	key           *rsa.PrivateKey
+	code          string
+	codeChallenge string
+	nonce         string
+	audience      string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, code: "test-code", audience: "chirpy"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		sum := sha256.Sum256([]byte(req.Form.Get("code_verifier")))
+		if req.Form.Get("code") != m.code || base64.RawURLEncoding.EncodeToString(sum[:]) != m.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		claims := Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    m.server.URL,
+				Subject:   "user-123",
+				Audience:  jwt.ClaimStrings{m.audience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+			Email:         "user@example.com",
+			EmailVerified: true,
+			Nonce:         m.nonce,
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func TestProvider(t *testing.T) {
+	verifier := "test-verifier-with-enough-entropy-for-pkce"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		audience string
+		wantErr  bool
+	}{
+		{
+			name:     "Basic login",
+			verifier: verifier,
+			nonce:    "test-nonce",
+			audience: "chirpy",
+			wantErr:  false,
+		},
+		{
+			name:     "Rejects wrong code verifier",
+			verifier: "wrong-verifier",
+			nonce:    "test-nonce",
+			audience: "chirpy",
+			wantErr:  true,
+		},
+		{
+			name:     "Rejects wrong nonce",
+			verifier: verifier,
+			nonce:    "other-nonce",
+			audience: "chirpy",
+			wantErr:  true,
+		},
+		{
+			name:     "Rejects token for another client",
+			verifier: verifier,
+			nonce:    "test-nonce",
+			audience: "someone-else",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockProvider(t)
+			mock.codeChallenge = challenge
+			mock.nonce = "test-nonce"
+			mock.audience = tt.audience
+			provider := NewProvider(mock.server.URL, "chirpy", "secret", "http://localhost:8080/callback")
+
+			authURL, err := provider.AuthCodeURL(context.Background(), "test-state", "test-nonce", challenge)
+			if err != nil {
+				t.Fatalf("AuthCodeURL() error = %v", err)
+			}
+			u, _ := url.Parse(authURL)
+			if u.Query().Get("code_challenge") != challenge || u.Query().Get("state") != "test-state" {
+				t.Errorf("AuthCodeURL() = %v, missing PKCE challenge or state", authURL)
+			}
+
+			claims, err := provider.Exchange(context.Background(), mock.code, tt.verifier, tt.nonce)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Exchange() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && (claims.Subject != "user-123" || claims.Email != "user@example.com") {
+				t.Errorf("Exchange() = %+v, want user-123 with user@example.com", claims)
+			}
+		})
+	}
+}
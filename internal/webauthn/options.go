@@ -0,0 +1,89 @@
+package webauthn
+
+// CreationOptions is passed to navigator.credentials.create() as the publicKey
+// option, once the binary fields have been decoded from base64url.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   User                   `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+}
+
+// RequestOptions is passed to navigator.credentials.get() as the publicKey option.
+type RequestOptions struct {
+	Challenge        string `json:"challenge"`
+	RPID             string `json:"rpId"`
+	Timeout          int    `json:"timeout"`
+	UserVerification string `json:"userVerification"`
+}
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type User struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// CreationOptions asks for a discoverable credential, so users can later log in without entering their email.
+func (c Config) CreationOptions(challenge, userHandle []byte, name string, exclude [][]byte) CreationOptions {
+	options := CreationOptions{
+		Challenge: encoding.EncodeToString(challenge),
+		RP:        RelyingParty{ID: c.RPID, Name: c.RPName},
+		User: User{
+			ID:          encoding.EncodeToString(userHandle),
+			Name:        name,
+			DisplayName: name,
+		},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: algES256},
+			{Type: "public-key", Alg: algEdDSA},
+			{Type: "public-key", Alg: algRS256},
+		},
+		Timeout:     Timeout,
+		Attestation: "none",
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		ExcludeCredentials: []CredentialDescriptor{},
+	}
+	for _, id := range exclude {
+		options.ExcludeCredentials = append(options.ExcludeCredentials, CredentialDescriptor{
+			Type: "public-key",
+			ID:   encoding.EncodeToString(id),
+		})
+	}
+	return options
+}
+
+func (c Config) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        encoding.EncodeToString(challenge),
+		RPID:             c.RPID,
+		Timeout:          Timeout,
+		UserVerification: "required",
+	}
+}
@@ -0,0 +1,290 @@
+// Package webauthn implements the relying party side of passkey registration and
+// authentication ceremonies. Attestation statements aren't verified as Chirpy
+// requests "none" attestation and doesn't restrict which authenticators can be used.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	algES256 = -7
+	algEdDSA = -8
+	algRS256 = -257
+
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+
+	// Timeout is how long, in milliseconds, clients are told to wait for the user.
+	Timeout = 300000
+)
+
+var encoding = base64.RawURLEncoding
+
+type Config struct {
+	RPID   string
+	RPName string
+	Origin string
+}
+
+// Credential is what needs to be stored to verify future assertions.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE encoded
+	SignCount uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// ChallengeFromClientData returns the challenge the client signed, so the
+// matching ceremony can be looked up before the response is verified.
+func ChallengeFromClientData(clientDataJSON []byte) ([]byte, error) {
+	var data clientData
+	err := json.Unmarshal(clientDataJSON, &data)
+	if err != nil {
+		return nil, err
+	}
+	return encoding.DecodeString(data.Challenge)
+}
+
+func (c Config) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (Credential, error) {
+	err := c.verifyClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return Credential{}, err
+	}
+	attestation, ok := decoded.(map[any]any)
+	if !ok {
+		return Credential{}, errors.New("attestation object isn't a map")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, errors.New("attestation object is missing authData")
+	}
+
+	authData, err := c.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if authData.flags&flagAttestedData == 0 {
+		return Credential{}, errors.New("authenticator data is missing credential data")
+	}
+
+	// Parse the key now so unsupported algorithms are rejected at registration rather than login.
+	_, err = parsePublicKey(authData.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks a login response against a stored credential and returns the new signature counter.
+func (c Config) VerifyAssertion(challenge, clientDataJSON, rawAuthData, signature []byte, cred Credential) (uint32, error) {
+	err := c.verifyClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	authData, err := c.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(bytes.Clone(rawAuthData), clientDataHash[:]...)
+	err = key.verify(signed, signature)
+	if err != nil {
+		return 0, err
+	}
+
+	// Authenticators that keep a counter must always increase it, otherwise the key may have been cloned.
+	if (authData.signCount != 0 || cred.SignCount != 0) && authData.signCount <= cred.SignCount {
+		return 0, errors.New("signature counter didn't increase")
+	}
+	return authData.signCount, nil
+}
+
+func (c Config) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	err := json.Unmarshal(clientDataJSON, &data)
+	if err != nil {
+		return err
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("unexpected client data type %q", data.Type)
+	}
+	if data.Challenge != encoding.EncodeToString(challenge) {
+		return errors.New("challenge doesn't match")
+	}
+	if data.Origin != c.Origin {
+		return fmt.Errorf("unexpected origin %q", data.Origin)
+	}
+	return nil
+}
+
+func (c Config) parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, errors.New("authenticator data is too short")
+	}
+
+	authData := authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return authenticatorData{}, errors.New("relying party id doesn't match")
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return authenticatorData{}, errors.New("user wasn't present")
+	}
+	// Passkey logins skip the password and TOTP, so the authenticator has to
+	// have checked a PIN or biometric itself.
+	if authData.flags&flagUserVerified == 0 {
+		return authenticatorData{}, errors.New("user wasn't verified")
+	}
+
+	if authData.flags&flagAttestedData != 0 {
+		rest := data[37:]
+		// 16 byte AAGUID followed by a 2 byte credential id length.
+		if len(rest) < 18 {
+			return authenticatorData{}, errors.New("credential data is too short")
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return authenticatorData{}, errors.New("credential data is too short")
+		}
+		authData.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, extensions, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, err
+		}
+		authData.publicKey = rest[:len(rest)-len(extensions)]
+	}
+	return authData, nil
+}
+
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func parsePublicKey(coseKey []byte) (publicKey, error) {
+	decoded, _, err := decodeCBOR(coseKey)
+	if err != nil {
+		return publicKey{}, err
+	}
+	params, ok := decoded.(map[any]any)
+	if !ok {
+		return publicKey{}, errors.New("public key isn't a map")
+	}
+	alg, _ := params[int64(3)].(int64)
+
+	switch alg {
+	case algES256:
+		x, _ := params[int64(-2)].([]byte)
+		y, _ := params[int64(-3)].([]byte)
+		if crv, _ := params[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, errors.New("invalid P-256 public key")
+		}
+		// ecdh rejects points that aren't on the curve.
+		_, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return publicKey{}, err
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		return publicKey{alg, key}, nil
+	case algEdDSA:
+		x, _ := params[int64(-2)].([]byte)
+		if crv, _ := params[int64(-1)].(int64); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errors.New("invalid Ed25519 public key")
+		}
+		return publicKey{alg, ed25519.PublicKey(x)}, nil
+	case algRS256:
+		n, _ := params[int64(-1)].([]byte)
+		e, _ := params[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, errors.New("invalid RSA public key")
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return publicKey{alg, key}, nil
+	}
+	return publicKey{}, fmt.Errorf("unsupported public key algorithm %d", alg)
+}
+
+func (k publicKey) verify(data, signature []byte) error {
+	switch k.alg {
+	case algES256:
+		hash := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(k.key.(*ecdsa.PublicKey), hash[:], signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case algEdDSA:
+		if !ed25519.Verify(k.key.(ed25519.PublicKey), data, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case algRS256:
+		hash := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.key.(*rsa.PublicKey), crypto.SHA256, hash[:], signature)
+	}
+	return fmt.Errorf("unsupported public key algorithm %d", k.alg)
+}
@@ -0,0 +1,246 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+// softwareAuthenticator stands in for a hardware key or platform passkey in tests.
+type softwareAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+	// presenceOnly acts like a security key without a PIN or biometric.
+	presenceOnly bool
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softwareAuthenticator{key: key, credentialID: id}
+}
+
+func (a *softwareAuthenticator) clientData(ceremony string, challenge []byte, origin string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": encoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+	return data
+}
+
+func (a *softwareAuthenticator) authData(rpID string, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	flags := byte(flagUserPresent)
+	if !a.presenceOnly {
+		flags |= flagUserVerified
+	}
+	if attested {
+		flags |= flagAttestedData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softwareAuthenticator) coseKey() []byte {
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	return encodeCBOR(map[any]any{
+		int64(1):  int64(2),
+		int64(3):  int64(algES256),
+		int64(-1): int64(1),
+		int64(-2): x,
+		int64(-3): y,
+	})
+}
+
+func (a *softwareAuthenticator) register(rpID, origin string, challenge []byte) (clientDataJSON, attestationObject []byte) {
+	clientDataJSON = a.clientData("webauthn.create", challenge, origin)
+	attestationObject = encodeCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authData(rpID, true),
+	})
+	return clientDataJSON, attestationObject
+}
+
+func (a *softwareAuthenticator) assert(rpID, origin string, challenge []byte) (clientDataJSON, authData, signature []byte) {
+	a.signCount++
+	clientDataJSON = a.clientData("webauthn.get", challenge, origin)
+	authData = a.authData(rpID, false)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	hash := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, _ = ecdsa.SignASN1(rand.Reader, a.key, hash[:])
+	return clientDataJSON, authData, signature
+}
+
+func encodeCBOR(v any) []byte {
+	header := func(major byte, n int) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+	}
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return header(1, int(-1-v))
+		}
+		return header(0, int(v))
+	case []byte:
+		return append(header(2, len(v)), v...)
+	case string:
+		return append(header(3, len(v)), v...)
+	case map[any]any:
+		keys := make([]any, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return string(encodeCBOR(keys[i])) < string(encodeCBOR(keys[j])) })
+		out := header(5, len(v))
+		for _, k := range keys {
+			out = append(out, encodeCBOR(k)...)
+			out = append(out, encodeCBOR(v[k])...)
+		}
+		return out
+	}
+	panic("unsupported type")
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	config := Config{RPID: "localhost", RPName: "Chirpy", Origin: "http://localhost:8080"}
+	authenticator := newSoftwareAuthenticator(t)
+	challenge, _ := NewChallenge()
+
+	tests := []struct {
+		name      string
+		rpID      string
+		origin    string
+		challenge []byte
+		wantErr   bool
+	}{
+		{
+			name:      "Basic registration",
+			rpID:      config.RPID,
+			origin:    config.Origin,
+			challenge: challenge,
+			wantErr:   false,
+		},
+		{
+			name:      "Rejects wrong origin",
+			rpID:      config.RPID,
+			origin:    "http://evil.example",
+			challenge: challenge,
+			wantErr:   true,
+		},
+		{
+			name:      "Rejects wrong relying party",
+			rpID:      "evil.example",
+			origin:    config.Origin,
+			challenge: challenge,
+			wantErr:   true,
+		},
+		{
+			name:      "Rejects wrong challenge",
+			rpID:      config.RPID,
+			origin:    config.Origin,
+			challenge: []byte("not the challenge"),
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientDataJSON, attestationObject := authenticator.register(tt.rpID, tt.origin, tt.challenge)
+			got, err := config.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRegistration() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && string(got.ID) != string(authenticator.credentialID) {
+				t.Errorf("VerifyRegistration() ID = %x, want %x", got.ID, authenticator.credentialID)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	config := Config{RPID: "localhost", RPName: "Chirpy", Origin: "http://localhost:8080"}
+	authenticator := newSoftwareAuthenticator(t)
+	challenge, _ := NewChallenge()
+	clientDataJSON, attestationObject := authenticator.register(config.RPID, config.Origin, challenge)
+	cred, err := config.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatalf("VerifyRegistration() error = %v", err)
+	}
+
+	t.Run("Basic assertion", func(t *testing.T) {
+		clientDataJSON, authData, signature := authenticator.assert(config.RPID, config.Origin, challenge)
+		got, err := config.VerifyAssertion(challenge, clientDataJSON, authData, signature, cred)
+		if err != nil {
+			t.Fatalf("VerifyAssertion() error = %v", err)
+		}
+		if got != authenticator.signCount {
+			t.Errorf("VerifyAssertion() = %v, want %v", got, authenticator.signCount)
+		}
+	})
+
+	t.Run("Rejects tampered signature", func(t *testing.T) {
+		clientDataJSON, authData, signature := authenticator.assert(config.RPID, config.Origin, challenge)
+		signature[len(signature)-1] ^= 0xff
+		_, err := config.VerifyAssertion(challenge, clientDataJSON, authData, signature, cred)
+		if err == nil {
+			t.Errorf("VerifyAssertion() error = nil, want error")
+		}
+	})
+
+	t.Run("Rejects signature counter going backwards", func(t *testing.T) {
+		clientDataJSON, authData, signature := authenticator.assert(config.RPID, config.Origin, challenge)
+		stale := cred
+		stale.SignCount = authenticator.signCount
+		_, err := config.VerifyAssertion(challenge, clientDataJSON, authData, signature, stale)
+		if err == nil {
+			t.Errorf("VerifyAssertion() error = nil, want error")
+		}
+	})
+
+	t.Run("Rejects assertion without user verification", func(t *testing.T) {
+		authenticator.presenceOnly = true
+		defer func() { authenticator.presenceOnly = false }()
+		clientDataJSON, authData, signature := authenticator.assert(config.RPID, config.Origin, challenge)
+		_, err := config.VerifyAssertion(challenge, clientDataJSON, authData, signature, cred)
+		if err == nil {
+			t.Errorf("VerifyAssertion() error = nil, want error")
+		}
+	})
+
+	t.Run("Rejects key from another authenticator", func(t *testing.T) {
+		other := newSoftwareAuthenticator(t)
+		clientDataJSON, authData, signature := other.assert(config.RPID, config.Origin, challenge)
+		_, err := config.VerifyAssertion(challenge, clientDataJSON, authData, signature, cred)
+		if err == nil {
+			t.Errorf("VerifyAssertion() error = nil, want error")
+		}
+	})
+}
@@ -0,0 +1,321 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455), as much as Chirpy needs: the opening handshake, text and binary
+// messages, fragmentation, ping/pong and the closing handshake. Extensions
+// and subprotocols aren't supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ContinuationMessage = 0x0
+	TextMessage         = 0x1
+	BinaryMessage       = 0x2
+	CloseMessage        = 0x8
+	PingMessage         = 0x9
+	PongMessage         = 0xa
+)
+
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+	CloseTryAgainLater   = 1013
+)
+
+// acceptGUID is appended to the client's key to prove the server speaks WebSocket.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	maxControlPayload = 125
+	writeTimeout      = time.Second * 10
+)
+
+var ErrMessageTooBig = errors.New("websocket: message too big")
+
+// CloseError is returned by ReadMessage once the peer has closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d [%v]", e.Code, e.Reason)
+}
+
+// Conn is an upgraded connection. One goroutine may read while others write.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+	closed  bool
+
+	// MaxMessageSize limits the size of a message, after any fragments are
+	// joined. Larger messages fail with ErrMessageTooBig.
+	MaxMessageSize int64
+	// IdleTimeout, if set, fails reads when nothing at all, not even a pong,
+	// has arrived for that long.
+	IdleTimeout time.Duration
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value for a client's Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// IsUpgrade reports whether the request asks to open a WebSocket.
+func IsUpgrade(req *http.Request) bool {
+	return headerContains(req.Header, "Connection", "upgrade") && headerContains(req.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake and takes over the connection. If
+// it returns an error, an error response has already been written.
+func Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	if req.Method != http.MethodGet || !IsUpgrade(req) {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: invalid key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Server can't upgrade the connection", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response writer can't be hijacked")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijacking connection: %w", err)
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	_, err = conn.Write([]byte(response))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: writing handshake: %w", err)
+	}
+	return newConn(conn, brw.Reader), nil
+}
+
+func newConn(conn net.Conn, br *bufio.Reader) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, MaxMessageSize: 1 << 20}
+}
+
+type frame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+func (c *Conn) readFrame(limit int64) (frame, error) {
+	if c.IdleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.IdleTimeout))
+	}
+	var header [2]byte
+	_, err := io.ReadFull(c.br, header[:])
+	if err != nil {
+		return frame{}, err
+	}
+
+	f := frame{fin: header[0]&0x80 != 0, opcode: int(header[0] & 0x0f)}
+	if header[0]&0x70 != 0 {
+		return frame{}, c.protocolError("reserved bits set")
+	}
+	if header[1]&0x80 == 0 {
+		return frame{}, c.protocolError("client frames must be masked")
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if err != nil {
+		return frame{}, err
+	}
+
+	control := f.opcode >= CloseMessage
+	if control && (!f.fin || length > maxControlPayload) {
+		return frame{}, c.protocolError("invalid control frame")
+	}
+	if length < 0 || (!control && length > limit) {
+		c.writeClose(CloseMessageTooBig, "")
+		return frame{}, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	_, err = io.ReadFull(c.br, mask[:])
+	if err != nil {
+		return frame{}, err
+	}
+	f.payload = make([]byte, length)
+	_, err = io.ReadFull(c.br, f.payload)
+	if err != nil {
+		return frame{}, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+func (c *Conn) protocolError(reason string) error {
+	c.writeClose(CloseProtocolError, reason)
+	return errors.New("websocket: " + reason)
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered and
+// pongs are skipped along the way. When the peer closes the connection the
+// close is acknowledged and a *CloseError returned.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	opcode := 0
+	var message []byte
+	for {
+		f, err := c.readFrame(c.MaxMessageSize - int64(len(message)))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case PingMessage:
+			err = c.WriteMessage(PongMessage, f.payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			closeErr := &CloseError{Code: CloseNoStatus}
+			if len(f.payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(f.payload))
+				closeErr.Reason = string(f.payload[2:])
+			}
+			c.writeClose(closeErr.Code, "")
+			return 0, nil, closeErr
+		case TextMessage, BinaryMessage:
+			if opcode != 0 {
+				return 0, nil, c.protocolError("expected a continuation frame")
+			}
+			opcode = f.opcode
+		case ContinuationMessage:
+			if opcode == 0 {
+				return 0, nil, c.protocolError("unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.protocolError("unknown opcode")
+		}
+
+		message = append(message, f.payload...)
+		if f.fin {
+			if opcode == TextMessage && !utf8.Valid(message) {
+				c.writeClose(CloseInvalidPayload, "")
+				return 0, nil, errors.New("websocket: text message isn't valid UTF-8")
+			}
+			return opcode, message, nil
+		}
+	}
+}
+
+// WriteMessage sends a message in a single frame. A write that hasn't
+// finished by the deadline fails, and the connection can't be used after.
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if opcode == CloseMessage {
+		c.closed = true
+	}
+
+	header := []byte{0x80 | byte(opcode)}
+	switch {
+	case len(data) < 126:
+		header = append(header, byte(len(data)))
+	case len(data) <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(data)))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(data)))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write(append(header, data...))
+	return err
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	if code == CloseNoStatus {
+		return c.WriteMessage(CloseMessage, nil)
+	}
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.WriteMessage(CloseMessage, append(payload, reason...))
+}
+
+// CloseWithReason starts the closing handshake and closes the connection.
+func (c *Conn) CloseWithReason(code int, reason string) error {
+	c.writeClose(code, reason)
+	return c.conn.Close()
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,308 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// clientFrame builds a masked frame, as a client would send it.
+func clientFrame(fin bool, opcode int, payload []byte) []byte {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	data := []byte{first}
+	switch {
+	case len(payload) < 126:
+		data = append(data, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		data = append(data, 0x80|126)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(payload)))
+	default:
+		data = append(data, 0x80|127)
+		data = binary.BigEndian.AppendUint64(data, uint64(len(payload)))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	data = append(data, mask...)
+	for i, b := range payload {
+		data = append(data, b^mask[i%4])
+	}
+	return data
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+	if got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("AcceptKey() = %v", got)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	unmasked := []byte{0x81, 0x02, 'h', 'i'}
+	tests := []struct {
+		name        string
+		input       [][]byte
+		wantOpcode  int
+		wantMessage string
+		wantErr     bool
+		wantClose   int
+		wantReply   []byte
+	}{
+		{
+			name:        "text message",
+			input:       [][]byte{clientFrame(true, TextMessage, []byte("hello"))},
+			wantOpcode:  TextMessage,
+			wantMessage: "hello",
+		},
+		{
+			name:        "binary message with extended length",
+			input:       [][]byte{clientFrame(true, BinaryMessage, bytes.Repeat([]byte{7}, 300))},
+			wantOpcode:  BinaryMessage,
+			wantMessage: string(bytes.Repeat([]byte{7}, 300)),
+		},
+		{
+			name: "fragmented message",
+			input: [][]byte{
+				clientFrame(false, TextMessage, []byte("hel")),
+				clientFrame(false, ContinuationMessage, []byte("l")),
+				clientFrame(true, ContinuationMessage, []byte("o")),
+			},
+			wantOpcode:  TextMessage,
+			wantMessage: "hello",
+		},
+		{
+			name: "ping between fragments is answered",
+			input: [][]byte{
+				clientFrame(false, TextMessage, []byte("hel")),
+				clientFrame(true, PingMessage, []byte("p")),
+				clientFrame(true, ContinuationMessage, []byte("lo")),
+			},
+			wantOpcode:  TextMessage,
+			wantMessage: "hello",
+			wantReply:   []byte{0x80 | PongMessage, 1, 'p'},
+		},
+		{
+			name:      "close is acknowledged",
+			input:     [][]byte{clientFrame(true, CloseMessage, closePayload(CloseGoingAway, "bye"))},
+			wantErr:   true,
+			wantClose: CloseGoingAway,
+			wantReply: []byte{0x80 | CloseMessage, 2, 0x03, 0xe9},
+		},
+		{
+			name:    "unmasked frame",
+			input:   [][]byte{unmasked},
+			wantErr: true,
+		},
+		{
+			name:    "message too big",
+			input:   [][]byte{clientFrame(true, TextMessage, make([]byte, 65))},
+			wantErr: true,
+		},
+		{
+			name: "fragments too big together",
+			input: [][]byte{
+				clientFrame(false, TextMessage, make([]byte, 40)),
+				clientFrame(true, ContinuationMessage, make([]byte, 40)),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "invalid UTF-8",
+			input:   [][]byte{clientFrame(true, TextMessage, []byte{0xff, 0xfe})},
+			wantErr: true,
+		},
+		{
+			name:    "continuation without a message",
+			input:   [][]byte{clientFrame(true, ContinuationMessage, []byte("x"))},
+			wantErr: true,
+		},
+		{
+			name:    "fragmented ping",
+			input:   [][]byte{clientFrame(false, PingMessage, []byte("x"))},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			go func() {
+				for _, frame := range tt.input {
+					client.Write(frame)
+				}
+			}()
+			replies := make(chan []byte)
+			go func() {
+				data, _ := io.ReadAll(client)
+				replies <- data
+			}()
+
+			conn := newConn(server, nil)
+			conn.MaxMessageSize = 64
+			if tt.wantOpcode == BinaryMessage {
+				conn.MaxMessageSize = 1024
+			}
+			opcode, message, err := conn.ReadMessage()
+			conn.Close()
+			reply := <-replies
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantClose != 0 {
+				var closeErr *CloseError
+				if !errors.As(err, &closeErr) || closeErr.Code != tt.wantClose {
+					t.Errorf("ReadMessage() error = %v, want close code %d", err, tt.wantClose)
+				}
+			}
+			if opcode != tt.wantOpcode || string(message) != tt.wantMessage {
+				t.Errorf("ReadMessage() = %d %q, want %d %q", opcode, message, tt.wantOpcode, tt.wantMessage)
+			}
+			if tt.wantReply != nil && !bytes.Equal(reply, tt.wantReply) {
+				t.Errorf("reply = %x, want %x", reply, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		length     int
+		wantHeader []byte
+	}{
+		{"short", 5, []byte{0x81, 5}},
+		{"16 bit length", 200, []byte{0x81, 126, 0, 200}},
+		{"64 bit length", 70000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0x11, 0x70}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			payload := bytes.Repeat([]byte("a"), tt.length)
+			go func() {
+				newConn(server, nil).WriteMessage(TextMessage, payload)
+				server.Close()
+			}()
+
+			data, _ := io.ReadAll(client)
+			want := append(tt.wantHeader, payload...)
+			if !bytes.Equal(data, want) {
+				t.Errorf("WriteMessage() wrote %x..., want %x...", data[:len(tt.wantHeader)], tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(TextMessage, message)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{
+			name: "valid",
+			headers: map[string]string{
+				"Connection":            "keep-alive, Upgrade",
+				"Upgrade":               "websocket",
+				"Sec-WebSocket-Version": "13",
+				"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+			},
+			wantStatus: http.StatusSwitchingProtocols,
+		},
+		{
+			name:       "not an upgrade",
+			headers:    map[string]string{},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unsupported version",
+			headers: map[string]string{
+				"Connection":            "Upgrade",
+				"Upgrade":               "websocket",
+				"Sec-WebSocket-Version": "8",
+				"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+			},
+			wantStatus: http.StatusUpgradeRequired,
+		},
+		{
+			name: "invalid key",
+			headers: map[string]string{
+				"Connection":            "Upgrade",
+				"Upgrade":               "websocket",
+				"Sec-WebSocket-Version": "13",
+				"Sec-WebSocket-Key":     "short",
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			req.Write(conn)
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				return
+			}
+			if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+				t.Errorf("Sec-WebSocket-Accept = %v", got)
+			}
+
+			conn.Write(clientFrame(true, TextMessage, []byte("echo")))
+			echo := make([]byte, 6)
+			_, err = io.ReadFull(br, echo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(echo, []byte{0x81, 4, 'e', 'c', 'h', 'o'}) {
+				t.Errorf("echo = %x", echo)
+			}
+		})
+	}
+}
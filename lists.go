@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const (
+	maxListNameLength        = 25
+	maxListDescriptionLength = 100
+	maxListMembers           = 500
+)
+
+type List struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	OwnerID     uuid.UUID `json:"owner_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	IsPrivate   bool      `json:"is_private"`
+}
+
+func mapToList(from database.List) List {
+	return List{
+		ID:          from.ID,
+		CreatedAt:   from.CreatedAt,
+		UpdatedAt:   from.UpdatedAt,
+		OwnerID:     from.OwnerID,
+		Name:        from.Name,
+		Description: from.Description,
+		IsPrivate:   from.IsPrivate,
+	}
+}
+
+type ListMember struct {
+	Profile
+	AddedAt time.Time `json:"added_at"`
+}
+
+type listDetails struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	IsPrivate   *bool   `json:"is_private"`
+}
+
+// validate trims the text fields and checks their lengths. Fields left out stay nil.
+func (d *listDetails) validate() error {
+	if d.Name != nil {
+		name := strings.TrimSpace(*d.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxListNameLength {
+			return fmt.Errorf("name must be between 1 and %d characters", maxListNameLength)
+		}
+		d.Name = &name
+	}
+	if d.Description != nil {
+		description := strings.TrimSpace(*d.Description)
+		if utf8.RuneCountInString(description) > maxListDescriptionLength {
+			return fmt.Errorf("description can't be longer than %d characters", maxListDescriptionLength)
+		}
+		d.Description = &description
+	}
+	return nil
+}
+
+// getViewableList reads the list from the path. Private lists, lists whose
+// owner can't be seen and lists whose owner has blocked the viewer, or been
+// blocked by them, are reported as not found to everyone but the owner.
+func getViewableList(w http.ResponseWriter, req *http.Request) (database.List, bool) {
+	listID, err := uuid.Parse(req.PathValue("listID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid list id")
+		return database.List{}, false
+	}
+
+	listDB, err := lookupViewableList(req.Context(), viewerID(req), listID)
+	if err != nil {
+		respondWithListLookupError(w, err)
+		return database.List{}, false
+	}
+	return listDB, true
+}
+
+// lookupViewableList is getViewableList without the response, lists the
+// viewer can't see are sql.ErrNoRows.
+func lookupViewableList(ctx context.Context, viewer uuid.NullUUID, listID uuid.UUID) (database.List, error) {
+	listDB, err := apiCfg.DB.GetList(ctx, listID)
+	if err != nil {
+		return database.List{}, err
+	}
+
+	if viewer.Valid && viewer.UUID == listDB.OwnerID {
+		return listDB, nil
+	}
+	if listDB.IsPrivate {
+		return database.List{}, sql.ErrNoRows
+	}
+	_, err = getUserVisibleTo(ctx, viewer, listDB.OwnerID.String())
+	if err != nil {
+		return database.List{}, err
+	}
+	return listDB, nil
+}
+
+// getOwnedList is getViewableList for changes, which only the owner can make.
+func getOwnedList(w http.ResponseWriter, req *http.Request) (database.List, bool) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return database.List{}, false
+	}
+
+	listID, err := uuid.Parse(req.PathValue("listID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid list id")
+		return database.List{}, false
+	}
+
+	listDB, err := apiCfg.DB.GetList(req.Context(), listID)
+	if err != nil {
+		respondWithListLookupError(w, err)
+		return database.List{}, false
+	}
+	if listDB.OwnerID != userID {
+		if listDB.IsPrivate {
+			respondWithListLookupError(w, sql.ErrNoRows)
+		} else {
+			respondWithError(w, http.StatusForbidden, "Forbidden")
+		}
+		return database.List{}, false
+	}
+	return listDB, true
+}
+
+func respondWithListLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "List not found.")
+		return
+	}
+	log.Printf("Error retreiving list: %v\n", err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func handlerAddList(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var details listDetails
+	err = json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	if details.Name == nil {
+		respondWithError(w, http.StatusBadRequest, "A name is required.")
+		return
+	}
+	err = details.validate()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.CreateListParams{OwnerID: userID, Name: *details.Name}
+	if details.Description != nil {
+		params.Description = *details.Description
+	}
+	if details.IsPrivate != nil {
+		params.IsPrivate = *details.IsPrivate
+	}
+	listDB, err := apiCfg.DB.CreateList(req.Context(), params)
+	if err != nil {
+		log.Printf("Error creating list: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, mapToList(listDB))
+}
+
+// handlerGetOwnLists lists the user's lists, private ones included.
+func handlerGetOwnLists(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	respondWithLists(w, req, userID, true)
+}
+
+// handlerGetUserLists lists another user's public lists.
+func handlerGetUserLists(w http.ResponseWriter, req *http.Request) {
+	userDB, err := getVisibleUser(req, req.PathValue("userID"))
+	if err != nil {
+		respondWithUserLookupError(w, err)
+		return
+	}
+	viewer := viewerID(req)
+	respondWithLists(w, req, userDB.ID, viewer.Valid && viewer.UUID == userDB.ID)
+}
+
+func respondWithLists(w http.ResponseWriter, req *http.Request, ownerID uuid.UUID, includePrivate bool) {
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetListsByOwnerParams{
+		OwnerID:        ownerID,
+		IncludePrivate: includePrivate,
+		PageLimit:      p.Limit,
+		PageOffset:     p.Offset,
+	}
+	listsDB, err := apiCfg.DB.GetListsByOwner(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving lists: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	lists := []List{}
+	for _, list := range listsDB {
+		lists = append(lists, mapToList(list))
+	}
+	respondWithJSON(w, http.StatusOK, lists)
+}
+
+func handlerGetList(w http.ResponseWriter, req *http.Request) {
+	listDB, ok := getViewableList(w, req)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, mapToList(listDB))
+}
+
+func handlerUpdateList(w http.ResponseWriter, req *http.Request) {
+	listDB, ok := getOwnedList(w, req)
+	if !ok {
+		return
+	}
+
+	var details listDetails
+	err := json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	err = details.validate()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.UpdateListParams{ID: listDB.ID}
+	if details.Name != nil {
+		params.Name = sql.NullString{String: *details.Name, Valid: true}
+	}
+	if details.Description != nil {
+		params.Description = sql.NullString{String: *details.Description, Valid: true}
+	}
+	if details.IsPrivate != nil {
+		params.IsPrivate = sql.NullBool{Bool: *details.IsPrivate, Valid: true}
+	}
+	listDB, err = apiCfg.DB.UpdateList(req.Context(), params)
+	if err != nil {
+		log.Printf("Error updating list: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, mapToList(listDB))
+}
+
+func handlerRemoveList(w http.ResponseWriter, req *http.Request) {
+	listDB, ok := getOwnedList(w, req)
+	if !ok {
+		return
+	}
+
+	err := apiCfg.DB.RemoveList(req.Context(), listDB.ID)
+	if err != nil {
+		log.Printf("Error removing list: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlerGetListMembers(w http.ResponseWriter, req *http.Request) {
+	listDB, ok := getViewableList(w, req)
+	if !ok {
+		return
+	}
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetListMembersParams{
+		ListID:     listDB.ID,
+		ViewerID:   viewerID(req),
+		PageLimit:  p.Limit,
+		PageOffset: p.Offset,
+	}
+	membersDB, err := apiCfg.DB.GetListMembers(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving list members: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	members := []ListMember{}
+	for _, member := range membersDB {
+		members = append(members, ListMember{mapToProfile(member.User), member.AddedAt})
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// handlerAddListMember adds a user the owner can see. Adding someone twice isn't an error.
+func handlerAddListMember(w http.ResponseWriter, req *http.Request) {
+	listDB, ok := getOwnedList(w, req)
+	if !ok {
+		return
+	}
+
+	userDB, err := getVisibleUser(req, req.PathValue("userID"))
+	if err != nil {
+		respondWithUserLookupError(w, err)
+		return
+	}
+
+	count, err := apiCfg.DB.CountListMembers(req.Context(), listDB.ID)
+	if err != nil {
+		log.Printf("Error counting list members: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if count >= maxListMembers {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Lists can't have more than %d members.", maxListMembers))
+		return
+	}
+
+	params := database.AddListMemberParams{ListID: listDB.ID, UserID: userDB.ID}
+	err = apiCfg.DB.AddListMember(req.Context(), params)
+	if err != nil {
+		log.Printf("Error adding list member: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlerRemoveListMember(w http.ResponseWriter, req *http.Request) {
+	listDB, ok := getOwnedList(w, req)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	params := database.RemoveListMemberParams{ListID: listDB.ID, UserID: userID}
+	rows, err := apiCfg.DB.RemoveListMember(req.Context(), params)
+	if err != nil {
+		log.Printf("Error removing list member: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "User isn't on the list.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerGetListChirps is the list's timeline, newest first.
+func handlerGetListChirps(w http.ResponseWriter, req *http.Request) {
+	listDB, ok := getViewableList(w, req)
+	if !ok {
+		return
+	}
+	p, err := parsePage(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetChirpsByListParams{
+		ListID:     listDB.ID,
+		ViewerID:   viewerID(req),
+		PageLimit:  p.Limit,
+		PageOffset: p.Offset,
+	}
+	chirpsDB, err := apiCfg.DB.GetChirpsByList(req.Context(), params)
+	if err != nil {
+		log.Printf("Error retreiving chirps: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	chirps := []Chirp{}
+	for _, chirp := range chirpsDB {
+		chirps = append(chirps, mapToChirp(chirp))
+	}
+	respondWithJSON(w, http.StatusOK, chirps)
+}
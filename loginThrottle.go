@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/mail"
+)
+
+// Failures are forgotten after a day without any. Shared IPs like offices and
+// mobile carriers get more headroom than a single account.
+var (
+	accountLoginPolicy = auth.LoginPolicy{BackoffAfter: 3, LockoutAfter: 10, MaxBackoff: time.Minute * 5, LockoutDuration: time.Minute * 30}
+	ipLoginPolicy      = auth.LoginPolicy{BackoffAfter: 20, LockoutAfter: 100, MaxBackoff: time.Minute * 5, LockoutDuration: time.Hour}
+)
+
+func accountLoginKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLoginKey(req *http.Request) string {
+	return "ip:" + clientIP(req)
+}
+
+// loginRetryAfter returns how long until logins are allowed for all of the keys.
+func loginRetryAfter(ctx context.Context, keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range keys {
+		failure, err := apiCfg.DB.GetLoginFailure(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if failure.LockedUntil.Valid {
+			wait = max(wait, time.Until(failure.LockedUntil.Time))
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failure against the key and pauses logins if the
+// policy calls for it. It reports whether this failure caused a full lockout.
+func recordLoginFailure(ctx context.Context, key string, policy auth.LoginPolicy) (bool, error) {
+	failure, err := apiCfg.DB.RecordLoginFailure(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	delay, locked := policy.Delay(failure.Failures)
+	if delay == 0 {
+		return false, nil
+	}
+	params := database.LockLoginParams{
+		Key:         key,
+		LockedUntil: sql.NullTime{Time: failure.LastFailureAt.Add(delay), Valid: true},
+	}
+	err = apiCfg.DB.LockLogin(ctx, params)
+	if err != nil {
+		return false, err
+	}
+	return locked && failure.Failures == policy.LockoutAfter, nil
+}
+
+func respondWithLoginLocked(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later.")
+}
+
+func sendLockoutEmail(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	userDB, err := apiCfg.DB.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error retreiving user: %v\n", err)
+		}
+		return
+	}
+
+	err = apiCfg.Mailer.Send(mail.Message{
+		To:      userDB.Email,
+		Subject: "Your Chirpy account has been locked",
+		Body: fmt.Sprintf(
+			"There were %d failed attempts to log in to your Chirpy account, so logging in has been paused for %v.\n\nIf this wasn't you, consider resetting your password.\n",
+			accountLoginPolicy.LockoutAfter, accountLoginPolicy.LockoutDuration,
+		),
+	})
+	if err != nil {
+		log.Printf("Error sending lockout email: %v\n", err)
+	}
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 
+	"github.com/samthesomebody/chirpy/internal/auth"
 	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/mail"
+	"github.com/samthesomebody/chirpy/internal/oidc"
+	"github.com/samthesomebody/chirpy/internal/webauthn"
 )
 
 var apiCfg *apiConfig
@@ -20,6 +30,23 @@ func main() {
 	platform := os.Getenv("PLATFORM")
 	tokenSecret := os.Getenv("TOKEN_SECRET")
 	polkaKey := os.Getenv("POLKA_KEY")
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	requireVerifiedEmail := os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+	webAuthn, err := newWebAuthnConfig(baseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.PasswordParams, err = newPasswordParams()
+	if err != nil {
+		log.Fatal(err)
+	}
+	passwordPolicy, err := newPasswordPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
 		log.Fatal(err)
@@ -27,36 +54,248 @@ func main() {
 
 	dbQueries := *database.New(db)
 	apiCfg = &apiConfig{
-		DB:          dbQueries,
-		Platform:    platform,
-		TokenSecret: tokenSecret,
-		PolkaKey:    polkaKey,
+		DB:                   dbQueries,
+		Conn:                 db,
+		Platform:             platform,
+		TokenSecret:          tokenSecret,
+		PolkaKey:             polkaKey,
+		BaseURL:              strings.TrimSuffix(baseURL, "/"),
+		Mailer:               newMailer(),
+		RequireVerifiedEmail: requireVerifiedEmail,
+		WebAuthn:             webAuthn,
+		OIDC:                 newOIDCProvider(baseURL),
+		PasswordPolicy:       passwordPolicy,
+		Stream:               newStreamHub(),
 	}
 
+	if len(os.Args) > 1 {
+		err := runCommand(context.Background(), os.Args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	go purgeDeletedUsers(context.Background())
+	go runDataExportWorker(context.Background())
+	go apiCfg.Stream.run(context.Background(), dbURL)
+
 	mux := http.NewServeMux()
 	handlerServeSite := http.StripPrefix("/app", http.FileServer(http.Dir(".")))
 	mux.Handle("/app/", apiCfg.middlewareMetricsInc(handlerServeSite))
 	mux.HandleFunc("GET /api/healthz", handlerGetHealth)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.getFileserverHits)
-	mux.HandleFunc("POST /admin/reset", handlerRemoveUsers)
+	mux.Handle("GET /admin/metrics", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(apiCfg.getFileserverHits)))
+	mux.Handle("GET /admin/users", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerAdminGetUsers)))
+	mux.Handle("GET /admin/users/{userID}", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerAdminGetUser)))
+	mux.Handle("DELETE /admin/users/{userID}", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerAdminDeleteUser)))
+	mux.Handle("GET /admin/users/{userID}/chirps", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerAdminGetUserChirps)))
+	mux.Handle("GET /admin/users/{userID}/sessions", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerAdminGetUserSessions)))
+	mux.Handle("POST /admin/users/{userID}/logout", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerAdminLogoutUser)))
+	mux.Handle("PUT /admin/users/{userID}/status", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerAdminSetUserStatus)))
+	mux.Handle("PUT /admin/users/{userID}/chirpy-red", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerAdminSetChirpyRed)))
+	mux.Handle("PUT /admin/users/{userID}/role", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerSetUserRole)))
+	mux.Handle("GET /admin/audit", apiCfg.middlewareRequireRole(roleAdmin, http.HandlerFunc(handlerGetAuditEvents)))
 	mux.HandleFunc("POST /api/users", handlerAddUser)
-	mux.HandleFunc("PUT /api/users", handlerUpdateUser)
+	mux.HandleFunc("DELETE /api/users/me", handlerDeleteOwnUser)
+	mux.HandleFunc("PATCH /api/users/me", handlerUpdateUser)
+	mux.HandleFunc("PUT /api/users", handlerUpdateUser) // deprecated, kept for older clients
+	mux.HandleFunc("PUT /api/users/me/avatar", handlerSetAvatar)
+	mux.HandleFunc("DELETE /api/users/me/avatar", handlerRemoveAvatar)
+	mux.HandleFunc("GET /api/users/{user}", handlerGetProfile)
+	mux.HandleFunc("GET /api/users/{userID}/avatar", handlerGetAvatar)
+	mux.HandleFunc("POST /api/users/{userID}/block", handlerBlockUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/block", handlerUnblockUser)
+	mux.HandleFunc("GET /api/blocks", handlerGetBlockedUsers)
+	mux.HandleFunc("POST /api/users/{userID}/mute", handlerMuteUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/mute", handlerUnmuteUser)
+	mux.HandleFunc("GET /api/mutes", handlerGetMutedUsers)
+	mux.HandleFunc("POST /api/lists", handlerAddList)
+	mux.HandleFunc("GET /api/lists", handlerGetOwnLists)
+	mux.HandleFunc("GET /api/users/{userID}/lists", handlerGetUserLists)
+	mux.HandleFunc("GET /api/lists/{listID}", handlerGetList)
+	mux.HandleFunc("PATCH /api/lists/{listID}", handlerUpdateList)
+	mux.HandleFunc("DELETE /api/lists/{listID}", handlerRemoveList)
+	mux.HandleFunc("GET /api/lists/{listID}/members", handlerGetListMembers)
+	mux.HandleFunc("PUT /api/lists/{listID}/members/{userID}", handlerAddListMember)
+	mux.HandleFunc("DELETE /api/lists/{listID}/members/{userID}", handlerRemoveListMember)
+	mux.HandleFunc("GET /api/lists/{listID}/chirps", handlerGetListChirps)
+	mux.HandleFunc("POST /api/users/restore", handlerRestoreUser)
+	mux.HandleFunc("POST /api/exports", handlerRequestDataExport)
+	mux.HandleFunc("GET /api/exports", handlerGetDataExports)
+	mux.HandleFunc("GET /api/exports/{exportID}", handlerGetDataExport)
+	mux.HandleFunc("GET /api/exports/{exportID}/download", handlerDownloadDataExport)
+	mux.HandleFunc("GET /api/email/verify", handlerVerifyEmail)
+	mux.HandleFunc("POST /api/email/verify/resend", handlerResendVerificationEmail)
+	mux.HandleFunc("GET /api/email/change/confirm", handlerConfirmEmailChange)
+	mux.HandleFunc("POST /api/password/forgot", handlerForgotPassword)
+	mux.HandleFunc("POST /api/password/reset", handlerResetPassword)
 	mux.HandleFunc("POST /api/login", handlerLoginUser)
+	mux.HandleFunc("POST /api/login/mfa", handlerLoginMFA)
+	mux.HandleFunc("GET /api/auth/oidc/login", handlerBeginOIDCLogin)
+	mux.HandleFunc("GET /api/auth/oidc/callback", handlerFinishOIDCLogin)
+	mux.HandleFunc("POST /api/login/passkey/begin", handlerBeginPasskeyLogin)
+	mux.HandleFunc("POST /api/login/passkey/finish", handlerFinishPasskeyLogin)
+	mux.HandleFunc("POST /api/passkeys/begin", handlerBeginPasskeyRegistration)
+	mux.HandleFunc("POST /api/passkeys/finish", handlerFinishPasskeyRegistration)
+	mux.HandleFunc("GET /api/passkeys", handlerGetPasskeys)
+	mux.HandleFunc("DELETE /api/passkeys/{passkeyID}", handlerRemovePasskey)
+	mux.HandleFunc("POST /api/mfa/totp/enroll", handlerEnrollTOTP)
+	mux.HandleFunc("POST /api/mfa/totp/confirm", handlerConfirmTOTP)
+	mux.HandleFunc("DELETE /api/mfa/totp", handlerDisableTOTP)
 	mux.HandleFunc("POST /api/refresh", handlerRefreshJWT)
 	mux.HandleFunc("POST /api/revoke", handlerRevokeRefreshToken)
+	mux.HandleFunc("POST /api/keys", handlerAddApiKey)
+	mux.HandleFunc("GET /api/keys", handlerGetApiKeys)
+	mux.HandleFunc("DELETE /api/keys/{keyID}", handlerRevokeApiKey)
+	mux.HandleFunc("POST /api/oauth/clients", handlerAddOAuthClient)
+	mux.HandleFunc("GET /api/oauth/clients", handlerGetOAuthClients)
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", handlerRemoveOAuthClient)
+	mux.HandleFunc("POST /api/oauth/authorize", handlerAuthorizeOAuth)
+	mux.HandleFunc("POST /api/oauth/token", handlerOAuthToken)
+	mux.HandleFunc("POST /api/oauth/introspect", handlerIntrospectOAuthToken)
+	mux.HandleFunc("POST /api/oauth/revoke", handlerRevokeOAuthToken)
+	mux.HandleFunc("GET /api/oauth/consents", handlerGetOAuthConsents)
+	mux.HandleFunc("DELETE /api/oauth/consents/{clientID}", handlerRemoveOAuthConsent)
+	mux.HandleFunc("POST /api/conversations", handlerAddConversation)
+	mux.HandleFunc("GET /api/conversations", handlerGetConversations)
+	mux.HandleFunc("GET /api/conversations/{conversationID}", handlerGetConversation)
+	mux.HandleFunc("POST /api/conversations/{conversationID}/messages", handlerAddMessage)
+	mux.HandleFunc("GET /api/conversations/{conversationID}/messages", handlerGetMessages)
+	mux.HandleFunc("POST /api/conversations/{conversationID}/read", handlerMarkConversationRead)
+	mux.HandleFunc("GET /api/stream", handlerStream)
+	mux.HandleFunc("POST /api/stream/token", handlerCreateStreamToken)
+	mux.HandleFunc("GET /api/ws", handlerSocket)
 	mux.HandleFunc("POST /api/chirps", handlerAddChirp)
 	mux.HandleFunc("GET /api/chirps", handlerGetChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", handlerGetChirp)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", handlerDeleteChirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/bookmark", handlerAddBookmark)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/bookmark", handlerRemoveBookmark)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}/pin", handlerPinChirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/pin", handlerUnpinChirp)
+	mux.HandleFunc("GET /api/bookmarks", handlerGetBookmarks)
 	mux.HandleFunc("POST /api/polka/webhooks", handlerPaymentWebhook)
 
 	server := &http.Server{}
 	server.Addr = ":8080"
-	server.Handler = mux
+	server.Handler = apiCfg.middlewareRequestID(mux)
 
 	log.Fatal(server.ListenAndServe())
 }
 
+// newPasswordParams lets ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM
+// override the default hashing cost. Existing hashes are upgraded as users log in.
+func newPasswordParams() (auth.Argon2Params, error) {
+	params := auth.PasswordParams
+	for _, setting := range []struct {
+		env   string
+		value *uint32
+	}{
+		{"ARGON2_MEMORY_KIB", &params.Memory},
+		{"ARGON2_ITERATIONS", &params.Iterations},
+	} {
+		raw := os.Getenv(setting.env)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil || value == 0 {
+			return auth.Argon2Params{}, fmt.Errorf("%v must be a positive integer", setting.env)
+		}
+		*setting.value = uint32(value)
+	}
+	if raw := os.Getenv("ARGON2_PARALLELISM"); raw != "" {
+		value, err := strconv.ParseUint(raw, 10, 8)
+		if err != nil || value == 0 {
+			return auth.Argon2Params{}, errors.New("ARGON2_PARALLELISM must be between 1 and 255")
+		}
+		params.Parallelism = uint8(value)
+	}
+	return params, nil
+}
+
+// newPasswordPolicy reads PASSWORD_MIN_LENGTH and PASSWORD_MIN_ENTROPY, and
+// opens the breached password list from BREACHED_PASSWORDS_FILE if it's set.
+func newPasswordPolicy() (auth.PasswordPolicy, error) {
+	policy := auth.DefaultPasswordPolicy
+	if raw := os.Getenv("PASSWORD_MIN_LENGTH"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 {
+			return auth.PasswordPolicy{}, errors.New("PASSWORD_MIN_LENGTH must be a positive integer")
+		}
+		policy.MinLength = value
+	}
+	if raw := os.Getenv("PASSWORD_MIN_ENTROPY"); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < 0 {
+			return auth.PasswordPolicy{}, errors.New("PASSWORD_MIN_ENTROPY must be a positive number")
+		}
+		policy.MinEntropy = value
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := auth.OpenBreachedPasswords(path)
+		if err != nil {
+			return auth.PasswordPolicy{}, err
+		}
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+// newMailer sends mail over SMTP when a host is configured, otherwise it writes
+// messages to MAIL_LOG_FILE (or the log) so links can be followed in development.
+func newMailer() mail.Sender {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return mail.LogSender{Path: os.Getenv("MAIL_LOG_FILE")}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return mail.SMTPSender{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}
+}
+
+// newWebAuthnConfig defaults the relying party to the host and origin of the site's base url.
+func newWebAuthnConfig(baseURL string) (webauthn.Config, error) {
+	origin := os.Getenv("WEBAUTHN_ORIGIN")
+	if origin == "" {
+		origin = strings.TrimSuffix(baseURL, "/")
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return webauthn.Config{}, err
+		}
+		rpID = u.Hostname()
+	}
+
+	return webauthn.Config{RPID: rpID, RPName: "Chirpy", Origin: origin}, nil
+}
+
+// newOIDCProvider returns nil unless single sign-on has been configured.
+func newOIDCProvider(baseURL string) *oidc.Provider {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = strings.TrimSuffix(baseURL, "/") + "/api/auth/oidc/callback"
+	}
+	return oidc.NewProvider(issuer, os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), redirectURL)
+}
+
 func handlerGetHealth(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
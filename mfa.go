@@ -0,0 +1,289 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const recoveryCodeCount = 10
+
+// Each MFA challenge allows a handful of guesses before the user has to wait.
+var mfaLimiter = auth.NewRateLimiter(5, time.Minute*5)
+
+func respondWithMFAChallenge(w http.ResponseWriter, userDB database.User) {
+	token, err := auth.MakeMFAToken(userDB.ID, apiCfg.TokenSecret)
+	if err != nil {
+		log.Printf("Error generating MFA token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}{true, token})
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code.
+func verifySecondFactor(req *http.Request, userDB database.User, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		params := database.UseRecoveryCodeParams{
+			UserID:   userDB.ID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)),
+		}
+		rows, err := apiCfg.DB.UseRecoveryCode(req.Context(), params)
+		return rows > 0, err
+	}
+
+	step, ok := auth.ValidateTOTP(userDB.TotpSecret.String, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	// Only succeeds if the code's time step is newer than the last one used, so codes can't be replayed.
+	params := database.UpdateTOTPLastStepParams{ID: userDB.ID, TotpLastStep: step}
+	rows, err := apiCfg.DB.UpdateTOTPLastStep(req.Context(), params)
+	return rows > 0, err
+}
+
+func handlerLoginMFA(w http.ResponseWriter, req *http.Request) {
+	var details struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	userID, err := auth.ValidateMFAToken(details.MFAToken, apiCfg.TokenSecret)
+	if err != nil {
+		log.Printf("Error validating MFA token: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !mfaLimiter.Allow(userID.String()) {
+		respondWithError(w, http.StatusTooManyRequests, "Too many attempts, try again later.")
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !userDB.TotpEnabled {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication isn't enabled.")
+		return
+	}
+
+	ok, err := verifySecondFactor(req, userDB, details.Code, details.RecoveryCode)
+	if err != nil {
+		log.Printf("Error verifying second factor: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		recordAuditEvent(req, auditEvent{
+			Type:     auditLoginFailure,
+			TargetID: userDB.ID,
+			Details:  map[string]any{"method": "mfa"},
+		})
+		respondWithError(w, http.StatusUnauthorized, "Incorrect code")
+		return
+	}
+
+	issueSession(w, req, userDB, "mfa")
+}
+
+func handlerEnrollTOTP(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if userDB.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled.")
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.SetTOTPSecretParams{
+		ID:         userID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	}
+	err = apiCfg.DB.SetTOTPSecret(req.Context(), params)
+	if err != nil {
+		log.Printf("Error saving TOTP secret: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{secret, auth.TOTPURI(secret, userDB.Email, "Chirpy")})
+}
+
+func handlerConfirmTOTP(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var details struct {
+		Code string `json:"code"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if userDB.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled.")
+		return
+	}
+	if !userDB.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication enrollment hasn't been started.")
+		return
+	}
+
+	ok, err := verifySecondFactor(req, userDB, details.Code, "")
+	if err != nil {
+		log.Printf("Error verifying TOTP code: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Incorrect code")
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(req, userID)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = apiCfg.DB.EnableTOTP(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error enabling TOTP: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Recovery codes are stored hashed, this is the only time they're shown.
+	respondWithJSON(w, http.StatusOK, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes})
+}
+
+func handlerDisableTOTP(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var details struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !userDB.TotpEnabled {
+		respondWithError(w, http.StatusBadRequest, "Two-factor authentication isn't enabled.")
+		return
+	}
+
+	ok, err := verifySecondFactor(req, userDB, details.Code, details.RecoveryCode)
+	if err != nil {
+		log.Printf("Error verifying second factor: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Incorrect code")
+		return
+	}
+
+	err = apiCfg.DB.DisableTOTP(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error disabling TOTP: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = apiCfg.DB.RemoveRecoveryCodes(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error removing recovery codes: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{Type: auditMFADisable, ActorID: userID, TargetID: userID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func replaceRecoveryCodes(req *http.Request, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = apiCfg.DB.RemoveRecoveryCodes(req.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashToken(auth.NormalizeRecoveryCode(code))
+	}
+	params := database.CreateRecoveryCodesParams{CodeHashes: hashes, UserID: userID}
+	err = apiCfg.DB.CreateRecoveryCodes(req.Context(), params)
+	return codes, err
+}
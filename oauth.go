@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const (
+	oauthCodeExpiry         = time.Minute * 10
+	oauthAccessTokenExpiry  = time.Hour
+	oauthRefreshTokenExpiry = time.Hour * 24 * 60
+)
+
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+}
+
+func mapToOAuthClient(from database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ClientID:     from.ID,
+		CreatedAt:    from.CreatedAt,
+		Name:         from.Name,
+		RedirectURIs: from.RedirectUris,
+		Confidential: from.SecretHash.Valid,
+	}
+}
+
+type OAuthConsent struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// respondWithOAuthError uses the error format from RFC 6749 so standard client libraries understand it.
+func respondWithOAuthError(w http.ResponseWriter, code int, oauthError, description string) {
+	respondWithJSON(w, code, struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}{oauthError, description})
+}
+
+func parseScopes(scope string) ([]string, bool) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return nil, false
+	}
+	for _, s := range scopes {
+		if !slices.Contains(delegatedScopes, s) {
+			return nil, false
+		}
+	}
+	return scopes, true
+}
+
+func handlerAddOAuthClient(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var details struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	if details.Name == "" || len(details.Name) > 64 {
+		respondWithError(w, http.StatusBadRequest, "Name must be between 1 and 64 characters.")
+		return
+	}
+	if len(details.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one redirect uri is required.")
+		return
+	}
+	for _, redirectURI := range details.RedirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			respondWithError(w, http.StatusBadRequest, "Redirect uris must be absolute and can't have a fragment.")
+			return
+		}
+	}
+
+	clientID, _ := auth.MakeRefreshToken() // err is always nil
+	params := database.CreateOAuthClientParams{
+		ID:           clientID[:32],
+		OwnerID:      userID,
+		Name:         details.Name,
+		RedirectUris: details.RedirectURIs,
+	}
+	var secret string
+	if details.Confidential {
+		secret, _ = auth.MakeRefreshToken()
+		params.SecretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	clientDB, err := apiCfg.DB.CreateOAuthClient(req.Context(), params)
+	if err != nil {
+		log.Printf("Error creating oauth client: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The secret is only stored as a hash, this is the only time it's shown.
+	client := mapToOAuthClient(clientDB)
+	client.ClientSecret = secret
+	respondWithJSON(w, http.StatusCreated, client)
+}
+
+func handlerGetOAuthClients(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	clientsDB, err := apiCfg.DB.GetOAuthClientsByOwner(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving oauth clients: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	clients := []OAuthClient{}
+	for _, client := range clientsDB {
+		clients = append(clients, mapToOAuthClient(client))
+	}
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+func handlerRemoveOAuthClient(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	params := database.RemoveOAuthClientParams{ID: req.PathValue("clientID"), OwnerID: userID}
+	rows, err := apiCfg.DB.RemoveOAuthClient(req.Context(), params)
+	if err != nil {
+		log.Printf("Error removing oauth client: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Client not found.")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAuthorizeOAuth is called by Chirpy's consent screen once the user has
+// approved or denied a client. It returns the url to send the user back to.
+func handlerAuthorizeOAuth(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var details struct {
+		ResponseType        string `json:"response_type"`
+		ClientID            string `json:"client_id"`
+		RedirectURI         string `json:"redirect_uri"`
+		Scope               string `json:"scope"`
+		State               string `json:"state"`
+		CodeChallenge       string `json:"code_challenge"`
+		CodeChallengeMethod string `json:"code_challenge_method"`
+		Approve             bool   `json:"approve"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	clientDB, err := apiCfg.DB.GetOAuthClient(req.Context(), details.ClientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Unknown client")
+			return
+		}
+		log.Printf("Error retreiving oauth client: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Until the redirect uri is known to be registered, errors can't be sent back to the client.
+	if !slices.Contains(clientDB.RedirectUris, details.RedirectURI) {
+		respondWithError(w, http.StatusBadRequest, "Redirect uri isn't registered for this client")
+		return
+	}
+
+	redirect, _ := url.Parse(details.RedirectURI)
+	query := redirect.Query()
+	if details.State != "" {
+		query.Set("state", details.State)
+	}
+	respondWithRedirect := func() {
+		redirect.RawQuery = query.Encode()
+		respondWithJSON(w, http.StatusOK, struct {
+			RedirectURI string `json:"redirect_uri"`
+		}{redirect.String()})
+	}
+
+	scopes, ok := parseScopes(details.Scope)
+	switch {
+	case details.ResponseType != "code":
+		query.Set("error", "unsupported_response_type")
+	case details.CodeChallenge == "" || details.CodeChallengeMethod != "S256":
+		query.Set("error", "invalid_request")
+		query.Set("error_description", "PKCE with S256 is required")
+	case !ok:
+		query.Set("error", "invalid_scope")
+	case !details.Approve:
+		query.Set("error", "access_denied")
+	}
+	if query.Has("error") {
+		respondWithRedirect()
+		return
+	}
+
+	consentParams := database.UpsertOAuthConsentParams{UserID: userID, ClientID: clientDB.ID, Scopes: scopes}
+	err = apiCfg.DB.UpsertOAuthConsent(req.Context(), consentParams)
+	if err != nil {
+		log.Printf("Error saving oauth consent: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	code, _ := auth.MakeRefreshToken() // err is always nil
+	codeParams := database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      clientDB.ID,
+		UserID:        userID,
+		RedirectUri:   details.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: details.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeExpiry),
+	}
+	err = apiCfg.DB.CreateOAuthAuthorizationCode(req.Context(), codeParams)
+	if err != nil {
+		log.Printf("Error creating oauth authorization code: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	query.Set("code", code)
+	respondWithRedirect()
+}
+
+// authenticateOAuthClient accepts client credentials from basic auth or the form body.
+// Public clients only send their id, PKCE protects their authorization codes.
+func authenticateOAuthClient(req *http.Request) (database.OauthClient, error) {
+	clientID, secret, ok := req.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = req.PostForm.Get("client_id")
+		secret = req.PostForm.Get("client_secret")
+	}
+
+	clientDB, err := apiCfg.DB.GetOAuthClient(req.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	if clientDB.SecretHash.Valid {
+		hash := auth.HashToken(secret)
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(clientDB.SecretHash.String)) != 1 {
+			return database.OauthClient{}, errors.New("incorrect client secret")
+		}
+	}
+	return clientDB, nil
+}
+
+func handlerOAuthToken(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	err := req.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "")
+		return
+	}
+
+	clientDB, err := authenticateOAuthClient(req)
+	if err != nil {
+		log.Printf("Error authenticating oauth client: %v\n", err)
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	switch req.PostForm.Get("grant_type") {
+	case "authorization_code":
+		codeDB, err := apiCfg.DB.UseOAuthAuthorizationCode(req.Context(), auth.HashToken(req.PostForm.Get("code")))
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Error using oauth authorization code: %v\n", err)
+			}
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Code is invalid or has expired")
+			return
+		}
+		if codeDB.ClientID != clientDB.ID || codeDB.RedirectUri != req.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Code was issued to another client or redirect uri")
+			return
+		}
+		verifier := req.PostForm.Get("code_verifier")
+		if subtle.ConstantTimeCompare([]byte(auth.CodeChallengeS256(verifier)), []byte(codeDB.CodeChallenge)) != 1 {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Code verifier doesn't match")
+			return
+		}
+		respondWithOAuthTokens(w, req, clientDB, codeDB.UserID, codeDB.Scopes)
+	case "refresh_token":
+		refreshToken := req.PostForm.Get("refresh_token")
+		params := database.GetOAuthRefreshTokenParams{
+			Token:    refreshToken,
+			ClientID: sql.NullString{String: clientDB.ID, Valid: true},
+		}
+		tokenDB, err := apiCfg.DB.GetOAuthRefreshToken(req.Context(), params)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Error retreiving oauth refresh token: %v\n", err)
+			}
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Refresh token is invalid or has expired")
+			return
+		}
+		// Refresh tokens are rotated, each one can only be used once.
+		revokeParams := database.RevokeOAuthRefreshTokenParams{Token: refreshToken, ClientID: params.ClientID}
+		rows, err := apiCfg.DB.RevokeOAuthRefreshToken(req.Context(), revokeParams)
+		if err != nil {
+			log.Printf("Error revoking oauth refresh token: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if rows == 0 {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Refresh token has already been used")
+			return
+		}
+		respondWithOAuthTokens(w, req, clientDB, tokenDB.UserID, tokenDB.Scopes)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+func respondWithOAuthTokens(w http.ResponseWriter, req *http.Request, clientDB database.OauthClient, userID uuid.UUID, scopes []string) {
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if checkAccountStatus(userDB) != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Account is suspended or banned")
+		return
+	}
+
+	accessToken, err := auth.MakeOAuthAccessToken(userID, clientDB.ID, scopes, apiCfg.TokenSecret, oauthAccessTokenExpiry)
+	if err != nil {
+		log.Printf("Error generating oauth access token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken, _ := auth.MakeRefreshToken() // err is always nil
+	params := database.CreateOAuthRefreshTokenParams{
+		Token:     refreshToken,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(oauthRefreshTokenExpiry),
+		ClientID:  sql.NullString{String: clientDB.ID, Valid: true},
+		Scopes:    scopes,
+	}
+	_, err = apiCfg.DB.CreateOAuthRefreshToken(req.Context(), params)
+	if err != nil {
+		log.Printf("Error generating oauth refresh token database entry: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenExpiry.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+// checkOAuthConsent makes sure the user still consents to the access token's
+// client and scopes. Revoking consent, or deleting the client along with its
+// consents, stops the access tokens already issued from working straight away
+// rather than when they expire.
+func checkOAuthConsent(ctx context.Context, access auth.OAuthAccessToken) error {
+	params := database.GetOAuthConsentParams{UserID: access.UserID, ClientID: access.ClientID}
+	consentDB, err := apiCfg.DB.GetOAuthConsent(ctx, params)
+	if err != nil {
+		return fmt.Errorf("retreiving oauth consent: %w", err)
+	}
+	// Consent given again after being revoked doesn't bring old tokens back.
+	// Token times are only accurate to the second.
+	if access.IssuedAt.Before(consentDB.CreatedAt.Truncate(time.Second)) {
+		return errors.New("oauth consent was revoked after the token was issued")
+	}
+	for _, scope := range access.Scopes {
+		if !slices.Contains(consentDB.Scopes, scope) {
+			return fmt.Errorf("oauth consent no longer includes scope [%v]", scope)
+		}
+	}
+	return nil
+}
+
+// handlerIntrospectOAuthToken implements RFC 7662. Clients can only introspect their own tokens.
+func handlerIntrospectOAuthToken(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "")
+		return
+	}
+
+	clientDB, err := authenticateOAuthClient(req)
+	if err != nil {
+		log.Printf("Error authenticating oauth client: %v\n", err)
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	type introspection struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+	}
+
+	token := req.PostForm.Get("token")
+	access, err := auth.ValidateOAuthAccessToken(token, apiCfg.TokenSecret)
+	if err == nil && access.ClientID == clientDB.ID && checkOAuthConsent(req.Context(), access) == nil {
+		respondWithJSON(w, http.StatusOK, introspection{
+			Active:    true,
+			Scope:     strings.Join(access.Scopes, " "),
+			ClientID:  access.ClientID,
+			Subject:   access.UserID.String(),
+			ExpiresAt: access.ExpiresAt.Unix(),
+			TokenType: "Bearer",
+		})
+		return
+	}
+
+	params := database.GetOAuthRefreshTokenParams{
+		Token:    token,
+		ClientID: sql.NullString{String: clientDB.ID, Valid: true},
+	}
+	tokenDB, err := apiCfg.DB.GetOAuthRefreshToken(req.Context(), params)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error retreiving oauth refresh token: %v\n", err)
+		}
+		respondWithJSON(w, http.StatusOK, introspection{Active: false})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, introspection{
+		Active:    true,
+		Scope:     strings.Join(tokenDB.Scopes, " "),
+		ClientID:  clientDB.ID,
+		Subject:   tokenDB.UserID.String(),
+		ExpiresAt: tokenDB.ExpiresAt.Unix(),
+		TokenType: "refresh_token",
+	})
+}
+
+// handlerRevokeOAuthToken implements RFC 7009. Access tokens are short lived
+// JWTs and can't be revoked, so only refresh tokens are affected.
+func handlerRevokeOAuthToken(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "")
+		return
+	}
+
+	clientDB, err := authenticateOAuthClient(req)
+	if err != nil {
+		log.Printf("Error authenticating oauth client: %v\n", err)
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	params := database.RevokeOAuthRefreshTokenParams{
+		Token:    req.PostForm.Get("token"),
+		ClientID: sql.NullString{String: clientDB.ID, Valid: true},
+	}
+	_, err = apiCfg.DB.RevokeOAuthRefreshToken(req.Context(), params)
+	if err != nil {
+		log.Printf("Error revoking oauth refresh token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func handlerGetOAuthConsents(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	consentsDB, err := apiCfg.DB.GetOAuthConsentsByUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving oauth consents: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	consents := []OAuthConsent{}
+	for _, consent := range consentsDB {
+		consents = append(consents, OAuthConsent{
+			ClientID:   consent.ClientID,
+			ClientName: consent.ClientName,
+			Scopes:     consent.Scopes,
+			CreatedAt:  consent.CreatedAt,
+			UpdatedAt:  consent.UpdatedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, consents)
+}
+
+// handlerRemoveOAuthConsent revokes a client's access, along with any refresh tokens it holds.
+func handlerRemoveOAuthConsent(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	clientID := req.PathValue("clientID")
+	params := database.RemoveOAuthConsentParams{UserID: userID, ClientID: clientID}
+	rows, err := apiCfg.DB.RemoveOAuthConsent(req.Context(), params)
+	if err != nil {
+		log.Printf("Error removing oauth consent: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Consent not found.")
+		return
+	}
+
+	revokeParams := database.RevokeOAuthRefreshTokensForClientParams{
+		UserID:   userID,
+		ClientID: sql.NullString{String: clientID, Valid: true},
+	}
+	err = apiCfg.DB.RevokeOAuthRefreshTokensForClient(req.Context(), revokeParams)
+	if err != nil {
+		log.Printf("Error revoking oauth refresh tokens: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{
+		Type:     auditOAuthConsentRevoke,
+		ActorID:  userID,
+		TargetID: userID,
+		Details:  map[string]any{"client_id": clientID},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
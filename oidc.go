@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/oidc"
+)
+
+const (
+	oidcLoginExpiry = time.Minute * 10
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+var errEmailTaken = errors.New("an account with this email already exists")
+
+// setOIDCStateCookie ties the login to the browser that started it, so a
+// callback URL from someone else's login can't sign this browser in.
+func setOIDCStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(apiCfg.BaseURL, "https://"),
+		// Lax, so the cookie is sent when the provider redirects back.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func handlerBeginOIDCLogin(w http.ResponseWriter, req *http.Request) {
+	if apiCfg.OIDC == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured.")
+		return
+	}
+
+	err := apiCfg.DB.RemoveExpiredOIDCLoginStates(req.Context())
+	if err != nil {
+		log.Printf("Error removing expired OIDC login states: %v\n", err)
+	}
+
+	state, _ := auth.MakeRefreshToken() // err is always nil
+	nonce, _ := auth.MakeRefreshToken()
+	verifier, err := auth.MakeCodeVerifier()
+	if err != nil {
+		log.Printf("Error generating PKCE verifier: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.CreateOIDCLoginStateParams{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginExpiry),
+	}
+	err = apiCfg.DB.CreateOIDCLoginState(req.Context(), params)
+	if err != nil {
+		log.Printf("Error creating OIDC login state: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := apiCfg.OIDC.AuthCodeURL(req.Context(), state, nonce, auth.CodeChallengeS256(verifier))
+	if err != nil {
+		log.Printf("Error building OIDC authorization url: %v\n", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	setOIDCStateCookie(w, state, int(oidcLoginExpiry.Seconds()))
+
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+func handlerFinishOIDCLogin(w http.ResponseWriter, req *http.Request) {
+	if apiCfg.OIDC == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured.")
+		return
+	}
+
+	query := req.URL.Query()
+	if query.Get("error") != "" {
+		log.Printf("OIDC provider returned an error: %v [%v]\n", query.Get("error"), query.Get("error_description"))
+		respondWithError(w, http.StatusUnauthorized, "Single sign-on failed.")
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := req.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login attempt is invalid or has expired.")
+		return
+	}
+	setOIDCStateCookie(w, "", -1)
+
+	stateDB, err := apiCfg.DB.UseOIDCLoginState(req.Context(), state)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Login attempt is invalid or has expired.")
+			return
+		}
+		log.Printf("Error retreiving OIDC login state: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	claims, err := apiCfg.OIDC.Exchange(req.Context(), query.Get("code"), stateDB.CodeVerifier, stateDB.Nonce)
+	if err != nil {
+		log.Printf("Error exchanging OIDC authorization code: %v\n", err)
+		respondWithError(w, http.StatusUnauthorized, "Single sign-on failed.")
+		return
+	}
+
+	userDB, err := findOrCreateOIDCUser(req.Context(), claims)
+	if err != nil {
+		if errors.Is(err, errEmailTaken) {
+			respondWithError(w, http.StatusConflict, "An account with this email already exists, log in to it first.")
+			return
+		}
+		log.Printf("Error finding user for OIDC identity: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Linking by email can reach an existing password account, which may
+	// have a second factor the provider knows nothing about.
+	if userDB.TotpEnabled {
+		respondWithMFAChallenge(w, userDB)
+		return
+	}
+
+	issueSession(w, req, userDB, "oidc")
+}
+
+// findOrCreateOIDCUser links an external identity to a user. Existing accounts
+// are only linked by email if the provider has verified the address.
+func findOrCreateOIDCUser(ctx context.Context, claims oidc.Claims) (database.User, error) {
+	identityParams := database.GetIdentityParams{Issuer: apiCfg.OIDC.Issuer, Subject: claims.Subject}
+	identity, err := apiCfg.DB.GetIdentity(ctx, identityParams)
+	if err == nil {
+		return apiCfg.DB.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if claims.Email == "" {
+		return database.User{}, errors.New("id token is missing an email")
+	}
+
+	userDB, err := apiCfg.DB.GetUserByEmail(ctx, claims.Email)
+	if err == nil && !claims.EmailVerified {
+		return database.User{}, errEmailTaken
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		userDB, err = createOIDCUser(ctx, claims)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	createParams := database.CreateIdentityParams{
+		UserID:  userDB.ID,
+		Issuer:  apiCfg.OIDC.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}
+	_, err = apiCfg.DB.CreateIdentity(ctx, createParams)
+	if err != nil {
+		return database.User{}, fmt.Errorf("creating identity: %w", err)
+	}
+	return userDB, nil
+}
+
+// createOIDCUser gives the user a random password, they can set one with a password reset if they want to log in without SSO.
+func createOIDCUser(ctx context.Context, claims oidc.Claims) (database.User, error) {
+	random, _ := auth.MakeRefreshToken() // err is always nil
+	password, err := auth.HashPassword(random)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	params := database.CreateUserParams{Email: claims.Email, HashedPassword: password}
+	userDB, err := apiCfg.DB.CreateUser(ctx, params)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if claims.EmailVerified {
+		return apiCfg.DB.VerifyUser(ctx, userDB.ID)
+	}
+	err = sendVerificationEmail(ctx, userDB)
+	if err != nil {
+		log.Printf("Error sending verification email: %v\n", err)
+	}
+	return userDB, nil
+}
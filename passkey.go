@@ -0,0 +1,331 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/webauthn"
+)
+
+const (
+	ceremonyRegistration   = "registration"
+	ceremonyAuthentication = "authentication"
+)
+
+type Passkey struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func mapToPasskey(from database.WebauthnCredential) Passkey {
+	passkey := Passkey{
+		ID:        from.ID,
+		CreatedAt: from.CreatedAt,
+		Name:      from.Name,
+	}
+	if from.LastUsedAt.Valid {
+		passkey.LastUsedAt = &from.LastUsedAt.Time
+	}
+	return passkey
+}
+
+// passkeyResponse is a PublicKeyCredential serialized by the client, with binary fields base64url encoded.
+type passkeyResponse struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// beginCeremony stores a new single use challenge, it's consumed by the matching finish handler.
+func beginCeremony(req *http.Request, userID uuid.NullUUID, ceremony string) ([]byte, error) {
+	err := apiCfg.DB.RemoveExpiredWebauthnChallenges(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	params := database.CreateWebauthnChallengeParams{
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		ExpiresAt: time.Now().Add(time.Duration(webauthn.Timeout) * time.Millisecond),
+	}
+	err = apiCfg.DB.CreateWebauthnChallenge(req.Context(), params)
+	return challenge, err
+}
+
+func finishCeremony(req *http.Request, clientDataJSON []byte, ceremony string) (database.WebauthnChallenge, []byte, error) {
+	challenge, err := webauthn.ChallengeFromClientData(clientDataJSON)
+	if err != nil {
+		return database.WebauthnChallenge{}, nil, err
+	}
+
+	params := database.UseWebauthnChallengeParams{
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Ceremony:  ceremony,
+	}
+	challengeDB, err := apiCfg.DB.UseWebauthnChallenge(req.Context(), params)
+	return challengeDB, challenge, err
+}
+
+func handlerBeginPasskeyRegistration(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	credentialsDB, err := apiCfg.DB.GetWebauthnCredentialsByUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving passkeys: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	exclude := [][]byte{}
+	for _, credential := range credentialsDB {
+		id, err := decodeBase64URL(credential.ID)
+		if err == nil {
+			exclude = append(exclude, id)
+		}
+	}
+
+	challenge, err := beginCeremony(req, uuid.NullUUID{UUID: userID, Valid: true}, ceremonyRegistration)
+	if err != nil {
+		log.Printf("Error creating passkey challenge: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	options := apiCfg.WebAuthn.CreationOptions(challenge, userID[:], userDB.Email, exclude)
+	respondWithJSON(w, http.StatusOK, struct {
+		PublicKey webauthn.CreationOptions `json:"publicKey"`
+	}{options})
+}
+
+func handlerFinishPasskeyRegistration(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var credential passkeyResponse
+	err = json.NewDecoder(req.Body).Decode(&credential)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	if credential.Name == "" {
+		credential.Name = "Passkey"
+	}
+	clientDataJSON, err := decodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	attestationObject, err := decodeBase64URL(credential.Response.AttestationObject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	challengeDB, challenge, err := finishCeremony(req, clientDataJSON, ceremonyRegistration)
+	if err != nil {
+		log.Printf("Error finding passkey challenge: %v\n", err)
+		respondWithError(w, http.StatusBadRequest, "Passkey challenge is invalid or has expired.")
+		return
+	}
+	if challengeDB.UserID.UUID != userID {
+		respondWithError(w, http.StatusBadRequest, "Passkey challenge is invalid or has expired.")
+		return
+	}
+
+	verified, err := apiCfg.WebAuthn.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		log.Printf("Error verifying passkey registration: %v\n", err)
+		respondWithError(w, http.StatusBadRequest, "Passkey couldn't be verified.")
+		return
+	}
+
+	params := database.CreateWebauthnCredentialParams{
+		ID:        base64.RawURLEncoding.EncodeToString(verified.ID),
+		UserID:    userID,
+		Name:      credential.Name,
+		PublicKey: verified.PublicKey,
+		SignCount: int64(verified.SignCount),
+	}
+	credentialDB, err := apiCfg.DB.CreateWebauthnCredential(req.Context(), params)
+	if err != nil {
+		log.Printf("Error creating passkey database entry: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, mapToPasskey(credentialDB))
+}
+
+func handlerBeginPasskeyLogin(w http.ResponseWriter, req *http.Request) {
+	challenge, err := beginCeremony(req, uuid.NullUUID{}, ceremonyAuthentication)
+	if err != nil {
+		log.Printf("Error creating passkey challenge: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		PublicKey webauthn.RequestOptions `json:"publicKey"`
+	}{apiCfg.WebAuthn.RequestOptions(challenge)})
+}
+
+func handlerFinishPasskeyLogin(w http.ResponseWriter, req *http.Request) {
+	var credential passkeyResponse
+	err := json.NewDecoder(req.Body).Decode(&credential)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	clientDataJSON, err := decodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	authData, err := decodeBase64URL(credential.Response.AuthenticatorData)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+	signature, err := decodeBase64URL(credential.Response.Signature)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	_, challenge, err := finishCeremony(req, clientDataJSON, ceremonyAuthentication)
+	if err != nil {
+		log.Printf("Error finding passkey challenge: %v\n", err)
+		respondWithError(w, http.StatusBadRequest, "Passkey challenge is invalid or has expired.")
+		return
+	}
+
+	credentialDB, err := apiCfg.DB.GetWebauthnCredential(req.Context(), credential.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusUnauthorized, "Unknown passkey")
+			return
+		}
+		log.Printf("Error retreiving passkey: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if credential.Response.UserHandle != "" {
+		userHandle, err := decodeBase64URL(credential.Response.UserHandle)
+		if err != nil || string(userHandle) != string(credentialDB.UserID[:]) {
+			respondWithError(w, http.StatusUnauthorized, "Unknown passkey")
+			return
+		}
+	}
+
+	stored := webauthn.Credential{
+		PublicKey: credentialDB.PublicKey,
+		SignCount: uint32(credentialDB.SignCount),
+	}
+	signCount, err := apiCfg.WebAuthn.VerifyAssertion(challenge, clientDataJSON, authData, signature, stored)
+	if err != nil {
+		log.Printf("Error verifying passkey assertion: %v\n", err)
+		respondWithError(w, http.StatusUnauthorized, "Passkey couldn't be verified.")
+		return
+	}
+
+	params := database.UpdateWebauthnCredentialUsageParams{ID: credentialDB.ID, SignCount: int64(signCount)}
+	err = apiCfg.DB.UpdateWebauthnCredentialUsage(req.Context(), params)
+	if err != nil {
+		log.Printf("Error updating passkey usage: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), credentialDB.UserID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	issueSession(w, req, userDB, "passkey")
+}
+
+func handlerGetPasskeys(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	credentialsDB, err := apiCfg.DB.GetWebauthnCredentialsByUser(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving passkeys: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	passkeys := []Passkey{}
+	for _, credential := range credentialsDB {
+		passkeys = append(passkeys, mapToPasskey(credential))
+	}
+	respondWithJSON(w, http.StatusOK, passkeys)
+}
+
+func handlerRemovePasskey(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	params := database.RemoveWebauthnCredentialParams{ID: req.PathValue("passkeyID"), UserID: userID}
+	rows, err := apiCfg.DB.RemoveWebauthnCredential(req.Context(), params)
+	if err != nil {
+		log.Printf("Error removing passkey: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Passkey not found.")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/mail"
+)
+
+const (
+	passwordResetExpiry     = time.Hour
+	passwordResetsPerWindow = 3
+)
+
+var forgotPasswordLimiter = auth.NewRateLimiter(5, time.Hour)
+
+func handlerForgotPassword(w http.ResponseWriter, req *http.Request) {
+	if !forgotPasswordLimiter.Allow(clientIP(req)) {
+		respondWithError(w, http.StatusTooManyRequests, "Too many requests, try again later.")
+		return
+	}
+
+	var details struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&details)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	// The response never depends on whether the account exists, so the
+	// lookup and email happen after we've replied.
+	go sendPasswordResetEmail(details.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func sendPasswordResetEmail(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	userDB, err := apiCfg.DB.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error retreiving user: %v\n", err)
+		}
+		return
+	}
+
+	countParams := database.CountRecentPasswordResetTokensParams{
+		UserID:    userDB.ID,
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	count, err := apiCfg.DB.CountRecentPasswordResetTokens(ctx, countParams)
+	if err != nil {
+		log.Printf("Error counting password reset tokens: %v\n", err)
+		return
+	}
+	if count >= passwordResetsPerWindow {
+		log.Printf("Password reset limit reached for user [%v]\n", userDB.ID)
+		return
+	}
+
+	token, _ := auth.MakeRefreshToken() // err is always nil
+	params := database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userDB.ID,
+		ExpiresAt: time.Now().Add(passwordResetExpiry),
+	}
+	err = apiCfg.DB.CreatePasswordResetToken(ctx, params)
+	if err != nil {
+		log.Printf("Error creating password reset token: %v\n", err)
+		return
+	}
+
+	err = apiCfg.Mailer.Send(mail.Message{
+		To:      userDB.Email,
+		Subject: "Reset your Chirpy password",
+		Body:    fmt.Sprintf("Someone asked to reset the password for your Chirpy account. If this wasn't you, you can ignore this email.\n\nUse this token to choose a new password within the next hour:\n\n%s\n", token),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset email: %v\n", err)
+	}
+}
+
+func handlerResetPassword(w http.ResponseWriter, req *http.Request) {
+	var details struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&details)
+	if err != nil || details.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	// The token is only used up once the new password has been accepted.
+	userDB, err := apiCfg.DB.GetUserFromPasswordResetToken(req.Context(), auth.HashToken(details.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Reset token is invalid or has expired.")
+			return
+		}
+		log.Printf("Error retreiving password reset token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !checkPassword(w, details.Password, userDB.Email) {
+		return
+	}
+
+	password, err := auth.HashPassword(details.Password)
+	if err != nil {
+		log.Printf("Error hashing password: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// All or nothing, so a failure can't spend the token without changing the
+	// password or leave other sessions signed in.
+	var userID uuid.UUID
+	err = withTx(req.Context(), func(q *database.Queries) error {
+		var err error
+		userID, err = q.UsePasswordResetToken(req.Context(), auth.HashToken(details.Token))
+		if err != nil {
+			return err
+		}
+		params := database.UpdateUserPasswordParams{ID: userID, HashedPassword: password}
+		err = q.UpdateUserPassword(req.Context(), params)
+		if err != nil {
+			return fmt.Errorf("updating password: %w", err)
+		}
+		err = q.RevokeAllRefreshTokensForUser(req.Context(), userID)
+		if err != nil {
+			return fmt.Errorf("revoking refresh tokens: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Reset token is invalid or has expired.")
+			return
+		}
+		log.Printf("Error resetting password: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(req, auditEvent{Type: auditPasswordReset, ActorID: userID, TargetID: userID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxLocationLength    = 30
+	maxWebsiteLength     = 100
+	maxAvatarSize        = 1 << 20
+	maxAvatarDimension   = 2048
+)
+
+// Handles can't contain hyphens, so they never parse as a user id.
+var validHandle = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// reservedHandles would clash with paths under /api/users or be used to impersonate staff.
+var reservedHandles = []string{"me", "restore", "admin", "chirpy"}
+
+var avatarContentTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+// Profile is the public view of a user, without their email or account details.
+type Profile struct {
+	ID            uuid.UUID  `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Handle        *string    `json:"handle"`
+	DisplayName   string     `json:"display_name"`
+	Bio           string     `json:"bio"`
+	Location      string     `json:"location"`
+	Website       string     `json:"website"`
+	AvatarURL     *string    `json:"avatar_url"`
+	IsChirpyRed   bool       `json:"is_chirpy_red"`
+	PinnedChirpID *uuid.UUID `json:"pinned_chirp_id"`
+}
+
+func mapToProfile(from database.User) Profile {
+	profile := Profile{
+		ID:          from.ID,
+		CreatedAt:   from.CreatedAt,
+		DisplayName: from.DisplayName,
+		Bio:         from.Bio,
+		Location:    from.Location,
+		Website:     from.Website,
+		AvatarURL:   avatarURL(from),
+		IsChirpyRed: from.IsChirpyRed,
+	}
+	if from.Handle.Valid {
+		profile.Handle = &from.Handle.String
+	}
+	if from.PinnedChirpID.Valid {
+		profile.PinnedChirpID = &from.PinnedChirpID.UUID
+	}
+	return profile
+}
+
+// avatarURL includes when the avatar last changed so caches pick up a new one straight away.
+func avatarURL(user database.User) *string {
+	if !user.AvatarUpdatedAt.Valid {
+		return nil
+	}
+	u := fmt.Sprintf("/api/users/%s/avatar?v=%d", user.ID, user.AvatarUpdatedAt.Time.Unix())
+	return &u
+}
+
+// getVisibleUser looks a user up by id or handle. Restricted and deleted
+// users are reported as not found, the same as their chirps, and so are
+// users the viewer has blocked or been blocked by.
+func getVisibleUser(req *http.Request, idOrHandle string) (database.User, error) {
+	return getUserVisibleTo(req.Context(), viewerID(req), idOrHandle)
+}
+
+// getUserVisibleTo is getVisibleUser for a viewer that's already known, such
+// as a WebSocket whose token may have expired since it authenticated.
+func getUserVisibleTo(ctx context.Context, viewer uuid.NullUUID, idOrHandle string) (database.User, error) {
+	var userDB database.User
+	var err error
+	if id, parseErr := uuid.Parse(idOrHandle); parseErr == nil {
+		userDB, err = apiCfg.DB.GetUserByID(ctx, id)
+	} else {
+		userDB, err = apiCfg.DB.GetUserByHandle(ctx, idOrHandle)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	if checkAccountStatus(userDB) != nil {
+		return database.User{}, sql.ErrNoRows
+	}
+	if viewer.Valid {
+		params := database.IsBlockedEitherWayParams{BlockerID: viewer.UUID, BlockedID: userDB.ID}
+		blocked, err := apiCfg.DB.IsBlockedEitherWay(ctx, params)
+		if err != nil {
+			return database.User{}, err
+		}
+		if blocked {
+			return database.User{}, sql.ErrNoRows
+		}
+	}
+	return userDB, nil
+}
+
+// handlerGetProfile includes the user's pinned chirp, so it can be shown without another request.
+func handlerGetProfile(w http.ResponseWriter, req *http.Request) {
+	userDB, err := getVisibleUser(req, req.PathValue("user"))
+	if err != nil {
+		respondWithUserLookupError(w, err)
+		return
+	}
+
+	var pinned *Chirp
+	if userDB.PinnedChirpID.Valid {
+		chirpDB, err := apiCfg.DB.GetChirp(req.Context(), userDB.PinnedChirpID.UUID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error retreiving chirp: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			chirp := mapToChirp(chirpDB)
+			chirp.Pinned = true
+			pinned = &chirp
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Profile
+		PinnedChirp *Chirp `json:"pinned_chirp"`
+	}{mapToProfile(userDB), pinned})
+}
+
+// profileDetails are the profile fields of a PATCH /api/users/me body. Fields
+// left out are nil and aren't changed.
+type profileDetails struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	Location    *string `json:"location"`
+	Website     *string `json:"website"`
+}
+
+// profileParams validates the details. Empty strings clear a field, except the
+// handle which can be changed but not removed.
+func profileParams(userID uuid.UUID, details profileDetails) (database.UpdateUserProfileParams, error) {
+	params := database.UpdateUserProfileParams{ID: userID}
+	if details.Handle != nil {
+		if !validHandle.MatchString(*details.Handle) {
+			return params, errors.New("handle must be 3 to 30 letters, numbers or underscores")
+		}
+		if slices.Contains(reservedHandles, strings.ToLower(*details.Handle)) {
+			return params, errors.New("that handle is reserved")
+		}
+		params.Handle = sql.NullString{String: *details.Handle, Valid: true}
+	}
+	for _, field := range []struct {
+		name   string
+		value  *string
+		max    int
+		target *sql.NullString
+	}{
+		{"display_name", details.DisplayName, maxDisplayNameLength, &params.DisplayName},
+		{"bio", details.Bio, maxBioLength, &params.Bio},
+		{"location", details.Location, maxLocationLength, &params.Location},
+		{"website", details.Website, maxWebsiteLength, &params.Website},
+	} {
+		if field.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*field.value)
+		if utf8.RuneCountInString(value) > field.max {
+			return params, fmt.Errorf("%s can't be longer than %d characters", field.name, field.max)
+		}
+		*field.target = sql.NullString{String: value, Valid: true}
+	}
+	if params.Website.Valid && params.Website.String != "" {
+		u, err := url.Parse(params.Website.String)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return params, errors.New("website must be an http or https URL")
+		}
+	}
+	return params, nil
+}
+
+// handlerSetAvatar takes the raw image as the request body. Only formats the
+// standard library can decode are accepted, so the image is checked to be what it claims.
+func handlerSetAvatar(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxAvatarSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Avatar can't be larger than 1 MiB.")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Couldn't read avatar")
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	if !slices.Contains(avatarContentTypes, contentType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Avatar must be a PNG, JPEG or GIF image.")
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Avatar isn't a valid image.")
+		return
+	}
+	if config.Width > maxAvatarDimension || config.Height > maxAvatarDimension {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Avatar can't be larger than %dx%d pixels.", maxAvatarDimension, maxAvatarDimension))
+		return
+	}
+
+	params := database.SetAvatarParams{UserID: userID, ContentType: contentType, Image: data}
+	err = apiCfg.DB.SetAvatar(req.Context(), params)
+	if err != nil {
+		log.Printf("Error saving avatar: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, mapToUser(userDB))
+}
+
+func handlerRemoveAvatar(w http.ResponseWriter, req *http.Request) {
+	userID, err := authenticateUser(req, "")
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	rows, err := apiCfg.DB.RemoveAvatar(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error removing avatar: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "No avatar to remove.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlerGetAvatar(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+	_, err = getVisibleUser(req, userID.String())
+	if err != nil {
+		respondWithUserLookupError(w, err)
+		return
+	}
+
+	avatarDB, err := apiCfg.DB.GetAvatar(req.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "User has no avatar.")
+			return
+		}
+		log.Printf("Error retreiving avatar: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", avatarDB.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(avatarDB.Image)
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/samthesomebody/chirpy/internal/database"
+	"github.com/samthesomebody/chirpy/internal/websocket"
+)
+
+const (
+	socketMaxConnectionsPerUser = 5
+	socketMaxSubscriptions      = 20
+	socketMaxMessageSize        = 4096
+	socketMaxMessagesPerMinute  = 120
+	socketAuthTimeout           = time.Second * 10
+	socketPingInterval          = time.Second * 30
+	socketIdleTimeout           = socketPingInterval * 2
+)
+
+// socketConnections counts each user's open WebSockets.
+var socketConnections = struct {
+	mu     sync.Mutex
+	counts map[uuid.UUID]int
+}{counts: map[uuid.UUID]int{}}
+
+func acquireSocketConnection(userID uuid.UUID) bool {
+	socketConnections.mu.Lock()
+	defer socketConnections.mu.Unlock()
+	if socketConnections.counts[userID] >= socketMaxConnectionsPerUser {
+		return false
+	}
+	socketConnections.counts[userID]++
+	return true
+}
+
+func releaseSocketConnection(userID uuid.UUID) {
+	socketConnections.mu.Lock()
+	defer socketConnections.mu.Unlock()
+	socketConnections.counts[userID]--
+	if socketConnections.counts[userID] <= 0 {
+		delete(socketConnections.counts, userID)
+	}
+}
+
+// socketRequest is a message from the client.
+type socketRequest struct {
+	Type        string `json:"type"`
+	Token       string `json:"token"`
+	Channel     string `json:"channel"`
+	LastEventID *int64 `json:"last_event_id"`
+}
+
+// socketResponse is a message to the client.
+type socketResponse struct {
+	Type     string          `json:"type"`
+	UserID   *uuid.UUID      `json:"user_id,omitempty"`
+	Channel  string          `json:"channel,omitempty"`
+	Channels []string        `json:"channels,omitempty"`
+	ID       int64           `json:"id,omitempty"`
+	Event    string          `json:"event,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// socketChannel is something a client has subscribed to: "timeline",
+// "messages", "user:{userID}" or "list:{listID}".
+type socketChannel struct {
+	name     string
+	kind     string
+	targetID uuid.UUID
+	// members is who a list's chirps come from.
+	members      []uuid.UUID
+	replayedUpTo int64
+}
+
+// socketChannelError is a subscription the client can't have, it's sent
+// back to them rather than ending the connection.
+type socketChannelError string
+
+func (e socketChannelError) Error() string {
+	return string(e)
+}
+
+// parseSocketChannel checks the channel exists and the user can see it.
+func parseSocketChannel(ctx context.Context, userID uuid.UUID, name string) (*socketChannel, error) {
+	kind, target, _ := strings.Cut(name, ":")
+	channel := &socketChannel{name: name, kind: kind}
+	switch kind {
+	case "timeline", "messages":
+		if target != "" {
+			return nil, socketChannelError("Unknown channel.")
+		}
+		return channel, nil
+	case "user", "list":
+	default:
+		return nil, socketChannelError("Unknown channel.")
+	}
+
+	id, err := uuid.Parse(target)
+	if err != nil {
+		return nil, socketChannelError("Invalid " + kind + " id")
+	}
+	channel.targetID = id
+	err = channel.refresh(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if kind == "user" {
+			return nil, socketChannelError("User not found.")
+		}
+		return nil, socketChannelError("List not found.")
+	}
+	return channel, err
+}
+
+// refresh checks a user or list channel is still visible and reloads a list's
+// members. It's given the user the socket authenticated as, because the token
+// it authenticated with may have expired since.
+func (c *socketChannel) refresh(ctx context.Context, userID uuid.UUID) error {
+	viewer := uuid.NullUUID{UUID: userID, Valid: true}
+	switch c.kind {
+	case "user":
+		_, err := getUserVisibleTo(ctx, viewer, c.targetID.String())
+		return err
+	case "list":
+		_, err := lookupViewableList(ctx, viewer, c.targetID)
+		if err != nil {
+			return err
+		}
+		c.members, err = apiCfg.DB.GetListMemberIDs(ctx, c.targetID)
+		return err
+	}
+	return nil
+}
+
+// matches reports whether the event belongs on the channel. Mutes apply to
+// the timeline and lists, but not to a channel for the muted user's own chirps.
+func (c *socketChannel) matches(event database.StreamEvent, filter streamFilter) bool {
+	if event.ID <= c.replayedUpTo || !filter.allows(event) {
+		return false
+	}
+	chirp := strings.HasPrefix(event.EventType, "chirp.")
+	muted := event.ActorID.Valid && slices.Contains(filter.muted, event.ActorID.UUID)
+	switch c.kind {
+	case "timeline":
+		return chirp && !muted
+	case "messages":
+		return event.EventType == streamMessageCreated
+	case "user":
+		return chirp && event.ActorID.UUID == c.targetID
+	case "list":
+		return chirp && !muted && slices.Contains(c.members, event.ActorID.UUID)
+	}
+	return false
+}
+
+// socketSession is the state of one open WebSocket.
+type socketSession struct {
+	conn     *websocket.Conn
+	req      *http.Request
+	userID   uuid.UUID
+	filter   streamFilter
+	channels map[string]*socketChannel
+}
+
+func (s *socketSession) send(response socketResponse) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *socketSession) sendError(channel, message string) error {
+	return s.send(socketResponse{Type: "error", Channel: channel, Error: message})
+}
+
+// deliver sends the event once, listing every subscribed channel it belongs on.
+func (s *socketSession) deliver(event database.StreamEvent) error {
+	var channels []string
+	for _, channel := range s.channels {
+		if channel.matches(event, s.filter) {
+			channels = append(channels, channel.name)
+		}
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+	slices.Sort(channels)
+	return s.send(socketResponse{Type: "event", ID: event.ID, Event: event.EventType, Channels: channels, Data: event.Data})
+}
+
+func (s *socketSession) subscribe(request socketRequest) error {
+	if _, ok := s.channels[request.Channel]; ok {
+		return s.send(socketResponse{Type: "subscribed", Channel: request.Channel})
+	}
+	if len(s.channels) >= socketMaxSubscriptions {
+		return s.sendError(request.Channel, fmt.Sprintf("Connections can't have more than %d subscriptions.", socketMaxSubscriptions))
+	}
+	channel, err := parseSocketChannel(s.req.Context(), s.userID, request.Channel)
+	var channelErr socketChannelError
+	if errors.As(err, &channelErr) {
+		return s.sendError(request.Channel, channelErr.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.send(socketResponse{Type: "subscribed", Channel: request.Channel})
+	if err != nil {
+		return err
+	}
+	if request.LastEventID == nil {
+		s.channels[channel.name] = channel
+		return nil
+	}
+
+	// Catch the channel up on what the client missed. The same events may
+	// still be queued from the hub, they're skipped when they arrive.
+	missed, resetTo, err := getMissedStreamEvents(s.req.Context(), s.userID, *request.LastEventID)
+	if err != nil {
+		return fmt.Errorf("retreiving missed stream events: %w", err)
+	}
+	if resetTo > 0 {
+		// Too much was missed to replay, the client has to reload the channel instead.
+		channel.replayedUpTo = resetTo
+		err = s.send(socketResponse{Type: "reset", Channel: channel.name, ID: resetTo})
+		if err != nil {
+			return err
+		}
+	}
+	for _, event := range missed {
+		if channel.matches(event, s.filter) {
+			err = s.send(socketResponse{Type: "event", ID: event.ID, Event: event.EventType, Channels: []string{channel.name}, Data: event.Data})
+			if err != nil {
+				return err
+			}
+		}
+		channel.replayedUpTo = event.ID
+	}
+	s.channels[channel.name] = channel
+	return nil
+}
+
+func (s *socketSession) handle(request socketRequest) error {
+	switch request.Type {
+	case "subscribe":
+		return s.subscribe(request)
+	case "unsubscribe":
+		delete(s.channels, request.Channel)
+		return s.send(socketResponse{Type: "unsubscribed", Channel: request.Channel})
+	case "ping":
+		return s.send(socketResponse{Type: "pong"})
+	}
+	return s.sendError("", "Unknown message type.")
+}
+
+// refresh picks up changes made since the connection opened: the account
+// being restricted, new blocks and mutes, and changes to subscribed lists.
+func (s *socketSession) refresh() error {
+	userDB, err := apiCfg.DB.GetUserByID(s.req.Context(), s.userID)
+	if err != nil {
+		return fmt.Errorf("retreiving user: %w", err)
+	}
+	err = checkAccountStatus(userDB)
+	if err != nil {
+		return err
+	}
+
+	s.filter, err = loadStreamFilter(s.req.Context(), s.userID)
+	if err != nil {
+		return err
+	}
+	for name, channel := range s.channels {
+		err = channel.refresh(s.req.Context(), s.userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			delete(s.channels, name)
+			err = s.send(socketResponse{Type: "unsubscribed", Channel: name})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authenticateSocket returns the request the connection was authenticated
+// with. Browsers can't set headers on a WebSocket, so without an
+// Authorization header the first message has to carry the JWT instead.
+func authenticateSocket(conn *websocket.Conn, req *http.Request) (*http.Request, uuid.UUID, error) {
+	conn.IdleTimeout = socketAuthTimeout
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, uuid.UUID{}, err
+	}
+	var request socketRequest
+	err = json.Unmarshal(data, &request)
+	if err != nil || request.Type != "auth" || request.Token == "" {
+		return nil, uuid.UUID{}, errors.New("expected an auth message")
+	}
+
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", "Bearer "+request.Token)
+	userID, err := authenticateUser(authReq, "")
+	return authReq, userID, err
+}
+
+// handlerSocket serves the WebSocket API. Clients subscribe to channels and
+// are sent the stream events for them. Clients that fall too far behind, or
+// break the per-connection limits, are disconnected.
+func handlerSocket(w http.ResponseWriter, req *http.Request) {
+	var userID uuid.UUID
+	if req.Header.Get("Authorization") != "" {
+		var err error
+		userID, err = authenticateUser(req, "")
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+	}
+
+	conn, err := websocket.Upgrade(w, req)
+	if err != nil {
+		log.Printf("Error upgrading to WebSocket: %v\n", err)
+		return
+	}
+	defer conn.Close()
+	conn.MaxMessageSize = socketMaxMessageSize
+
+	if userID == uuid.Nil {
+		req, userID, err = authenticateSocket(conn, req)
+		if err != nil {
+			log.Printf("Error authenticating WebSocket: %v\n", err)
+			conn.CloseWithReason(websocket.ClosePolicyViolation, "Unauthorized")
+			return
+		}
+	}
+	if !acquireSocketConnection(userID) {
+		conn.CloseWithReason(websocket.CloseTryAgainLater, "Too many connections")
+		return
+	}
+	defer releaseSocketConnection(userID)
+
+	session := &socketSession{conn: conn, req: req, userID: userID, channels: map[string]*socketChannel{}}
+	session.filter, err = loadStreamFilter(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error loading stream filter: %v\n", err)
+		conn.CloseWithReason(websocket.CloseInternalError, "")
+		return
+	}
+
+	sub := apiCfg.Stream.subscribe(userID)
+	defer apiCfg.Stream.unsubscribe(sub)
+
+	err = session.send(socketResponse{Type: "ready", UserID: &userID})
+	if err != nil {
+		return
+	}
+
+	// The client's messages are read here and handled in the loop below, so
+	// only one goroutine ever writes events.
+	conn.IdleTimeout = socketIdleTimeout
+	requests := make(chan socketRequest)
+	readErr := make(chan error, 1)
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	go func() {
+		window := time.Now()
+		count := 0
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if time.Since(window) > time.Minute {
+				window, count = time.Now(), 0
+			}
+			count++
+			if count > socketMaxMessagesPerMinute {
+				readErr <- errors.New("too many messages")
+				return
+			}
+
+			var request socketRequest
+			if json.Unmarshal(data, &request) != nil {
+				readErr <- errors.New("message isn't valid JSON")
+				return
+			}
+			select {
+			case requests <- request:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case err := <-readErr:
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				conn.CloseWithReason(websocket.ClosePolicyViolation, err.Error())
+			}
+			return
+		case <-sub.dropped:
+			conn.CloseWithReason(websocket.CloseTryAgainLater, "Too far behind, reconnect with last_event_id")
+			return
+		case request := <-requests:
+			err = session.handle(request)
+		case event := <-sub.events:
+			err = session.deliver(event)
+		case <-ping.C:
+			err = session.refresh()
+			if err == nil {
+				err = conn.WriteMessage(websocket.PingMessage, nil)
+			}
+		}
+		if err != nil {
+			var restricted *accountRestrictedError
+			if errors.As(err, &restricted) {
+				conn.CloseWithReason(websocket.ClosePolicyViolation, "Account "+restricted.Status)
+				return
+			}
+			log.Printf("Error in WebSocket session: %v\n", err)
+			conn.CloseWithReason(websocket.CloseInternalError, "")
+			return
+		}
+	}
+}
@@ -0,0 +1,18 @@
+-- name: CreateApiKey :one
+INSERT INTO api_keys (id, created_at, updated_at, user_id, name, prefix, key_hash, scopes, expires_at)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetApiKeysByUser :many
+SELECT * FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at;
+
+-- name: GetApiKeyByHash :one
+SELECT * FROM api_keys
+WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW());
+
+-- name: TouchApiKey :exec
+UPDATE api_keys SET last_used_at = NOW() WHERE id = $1;
+
+-- name: RevokeApiKey :execrows
+UPDATE api_keys SET revoked_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
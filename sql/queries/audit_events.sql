@@ -0,0 +1,18 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (created_at, event_type, actor_id, target_id, ip, user_agent, request_id, details)
+VALUES (NOW(), $1, $2, $3, $4, $5, $6, $7);
+
+-- name: GetAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg('event_type')::text IS NULL OR event_type = sqlc.narg('event_type'))
+  AND (sqlc.narg('actor_id')::uuid IS NULL OR actor_id = sqlc.narg('actor_id'))
+  AND (sqlc.narg('target_id')::uuid IS NULL OR target_id = sqlc.narg('target_id'))
+  AND (sqlc.narg('ip')::text IS NULL OR ip = sqlc.narg('ip'))
+  AND (sqlc.narg('since')::timestamptz IS NULL OR created_at >= sqlc.narg('since'))
+  AND (sqlc.narg('until')::timestamptz IS NULL OR created_at < sqlc.narg('until'))
+  AND (sqlc.narg('before_id')::bigint IS NULL OR id < sqlc.narg('before_id'))
+ORDER BY id DESC
+LIMIT @max_results;
+
+-- name: GetAuditEventsForUser :many
+SELECT * FROM audit_events WHERE actor_id = $1 OR target_id = $1 ORDER BY id;
@@ -0,0 +1,21 @@
+-- name: SetAvatar :exec
+WITH avatar AS (
+  INSERT INTO avatars (user_id, updated_at, content_type, image)
+  VALUES ($1, NOW(), $2, $3)
+  ON CONFLICT (user_id) DO UPDATE
+  SET updated_at = EXCLUDED.updated_at, content_type = EXCLUDED.content_type, image = EXCLUDED.image
+  RETURNING user_id, updated_at
+)
+UPDATE users SET avatar_updated_at = avatar.updated_at, updated_at = NOW()
+FROM avatar WHERE users.id = avatar.user_id;
+
+-- name: GetAvatar :one
+SELECT * FROM avatars WHERE user_id = $1;
+
+-- name: RemoveAvatar :execrows
+WITH avatar AS (
+  DELETE FROM avatars WHERE user_id = $1
+  RETURNING user_id
+)
+UPDATE users SET avatar_updated_at = NULL, updated_at = NOW()
+FROM avatar WHERE users.id = avatar.user_id;
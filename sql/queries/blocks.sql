@@ -0,0 +1,49 @@
+-- name: BlockUser :exec
+INSERT INTO blocks (blocker_id, blocked_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: UnblockUser :execrows
+DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2;
+
+-- name: GetBlockedUsers :many
+SELECT sqlc.embed(users), blocks.created_at AS blocked_at
+FROM blocks JOIN users ON users.id = blocks.blocked_id
+WHERE blocks.blocker_id = $1
+ORDER BY blocks.created_at DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: IsBlockedEitherWay :one
+SELECT EXISTS (
+  SELECT 1 FROM blocks
+  WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+);
+
+-- name: MuteUser :exec
+INSERT INTO mutes (muter_id, muted_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: UnmuteUser :execrows
+DELETE FROM mutes WHERE muter_id = $1 AND muted_id = $2;
+
+-- name: GetMutedUsers :many
+SELECT sqlc.embed(users), mutes.created_at AS muted_at
+FROM mutes JOIN users ON users.id = mutes.muted_id
+WHERE mutes.muter_id = $1
+ORDER BY mutes.created_at DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: GetBlockedEitherWayIDs :many
+SELECT blocked_id AS user_id FROM blocks WHERE blocker_id = $1
+UNION
+SELECT blocker_id AS user_id FROM blocks WHERE blocked_id = $1;
+
+-- name: GetMutedIDs :many
+SELECT muted_id FROM mutes WHERE muter_id = $1;
+
+-- name: GetAllBlocksByUser :many
+SELECT blocked_id, created_at FROM blocks WHERE blocker_id = $1 ORDER BY created_at;
+
+-- name: GetAllMutesByUser :many
+SELECT muted_id, created_at FROM mutes WHERE muter_id = $1 ORDER BY created_at;
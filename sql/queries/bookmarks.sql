@@ -0,0 +1,26 @@
+-- name: AddBookmark :exec
+INSERT INTO bookmarks (user_id, chirp_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveBookmark :execrows
+DELETE FROM bookmarks WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: GetBookmarkedChirps :many
+-- Bookmarks of chirps that have since been hidden, by a restriction or a block, are skipped.
+SELECT sqlc.embed(chirps), bookmarks.created_at AS bookmarked_at
+FROM bookmarks
+JOIN chirps ON chirps.id = bookmarks.chirp_id
+JOIN users ON users.id = chirps.user_id
+WHERE bookmarks.user_id = sqlc.arg('user_id') AND users.deleted_at IS NULL
+  AND (users.status = 'active' OR users.status_expires_at <= NOW())
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE (blocks.blocker_id = sqlc.arg('user_id') AND blocks.blocked_id = chirps.user_id)
+       OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = sqlc.arg('user_id'))
+  )
+ORDER BY bookmarks.created_at DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: GetAllBookmarksByUser :many
+SELECT chirp_id, created_at FROM bookmarks WHERE user_id = $1 ORDER BY created_at;
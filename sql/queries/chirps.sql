@@ -4,13 +4,74 @@ VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
 RETURNING *;
 
 -- name: GetChirps :many
-SELECT * FROM chirps ORDER BY created_at;
+-- Chirps by suspended and banned users are hidden until the restriction expires or is lifted,
+-- and chirps by users who've deleted their account are hidden while it waits to be purged.
+-- When there's a viewer, chirps by users they've blocked, been blocked by or muted are hidden too.
+SELECT chirps.* FROM chirps JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL
+  AND (users.status = 'active' OR users.status_expires_at <= NOW())
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE (blocks.blocker_id = sqlc.narg('viewer_id')::uuid AND blocks.blocked_id = chirps.user_id)
+       OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = sqlc.narg('viewer_id')::uuid)
+  )
+  AND NOT EXISTS (
+    SELECT 1 FROM mutes
+    WHERE mutes.muter_id = sqlc.narg('viewer_id')::uuid AND mutes.muted_id = chirps.user_id
+  )
+ORDER BY chirps.created_at;
 
 -- name: GetChirpsByUser :many
+-- Muted users' chirps are still shown when asked for by author.
+SELECT chirps.* FROM chirps JOIN users ON users.id = chirps.user_id
+WHERE chirps.user_id = sqlc.arg('user_id') AND users.deleted_at IS NULL
+  AND (users.status = 'active' OR users.status_expires_at <= NOW())
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE (blocks.blocker_id = sqlc.narg('viewer_id')::uuid AND blocks.blocked_id = chirps.user_id)
+       OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = sqlc.narg('viewer_id')::uuid)
+  )
+ORDER BY chirps.created_at;
+
+-- name: GetChirpsByList :many
+-- The same visibility rules as GetChirps, for the members of a list, newest first.
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+JOIN list_members ON list_members.user_id = chirps.user_id
+WHERE list_members.list_id = sqlc.arg('list_id') AND users.deleted_at IS NULL
+  AND (users.status = 'active' OR users.status_expires_at <= NOW())
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE (blocks.blocker_id = sqlc.narg('viewer_id')::uuid AND blocks.blocked_id = chirps.user_id)
+       OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = sqlc.narg('viewer_id')::uuid)
+  )
+  AND NOT EXISTS (
+    SELECT 1 FROM mutes
+    WHERE mutes.muter_id = sqlc.narg('viewer_id')::uuid AND mutes.muted_id = chirps.user_id
+  )
+ORDER BY chirps.created_at DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: GetChirpsByUserPage :many
+SELECT * FROM chirps WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: GetAllChirpsByUser :many
 SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at;
 
 -- name: GetChirp :one
 SELECT * FROM chirps WHERE id=$1;
 
+-- name: GetVisibleChirp :one
+SELECT chirps.* FROM chirps JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = sqlc.arg('id') AND users.deleted_at IS NULL
+  AND (users.status = 'active' OR users.status_expires_at <= NOW())
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE (blocks.blocker_id = sqlc.narg('viewer_id')::uuid AND blocks.blocked_id = chirps.user_id)
+       OR (blocks.blocker_id = chirps.user_id AND blocks.blocked_id = sqlc.narg('viewer_id')::uuid)
+  );
+
 -- name: RemoveChirp :exec
 DELETE FROM chirps WHERE id=$1;
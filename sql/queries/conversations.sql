@@ -0,0 +1,106 @@
+-- name: CreateGroupConversation :one
+-- Creates the conversation and adds its participants in one statement.
+WITH conversation AS (
+  INSERT INTO conversations (id, created_at, updated_at, is_group)
+  VALUES (gen_random_uuid(), NOW(), NOW(), TRUE)
+  RETURNING *
+), participants AS (
+  INSERT INTO conversation_participants (conversation_id, user_id, joined_at, last_read_at)
+  SELECT conversation.id, participant_id, NOW(), NOW()
+  FROM conversation, unnest(sqlc.arg('participant_ids')::uuid[]) AS participant_id
+)
+SELECT * FROM conversation;
+
+-- name: CreateDirectConversation :one
+-- Returns no rows if the two users already have a conversation.
+WITH conversation AS (
+  INSERT INTO conversations (id, created_at, updated_at, is_group, pair_low_id, pair_high_id)
+  VALUES (
+    gen_random_uuid(), NOW(), NOW(), FALSE,
+    LEAST(sqlc.arg('user_id')::uuid, sqlc.arg('other_user_id')::uuid),
+    GREATEST(sqlc.arg('user_id')::uuid, sqlc.arg('other_user_id')::uuid)
+  )
+  ON CONFLICT (pair_low_id, pair_high_id) WHERE NOT is_group DO NOTHING
+  RETURNING *
+), participants AS (
+  INSERT INTO conversation_participants (conversation_id, user_id, joined_at, last_read_at)
+  SELECT conversation.id, participant_id, NOW(), NOW()
+  FROM conversation, unnest(ARRAY[sqlc.arg('user_id')::uuid, sqlc.arg('other_user_id')::uuid]) AS participant_id
+)
+SELECT * FROM conversation;
+
+-- name: GetDirectConversation :one
+SELECT * FROM conversations
+WHERE NOT is_group
+  AND pair_low_id = LEAST(sqlc.arg('user_id')::uuid, sqlc.arg('other_user_id')::uuid)
+  AND pair_high_id = GREATEST(sqlc.arg('user_id')::uuid, sqlc.arg('other_user_id')::uuid);
+
+-- name: GetConversationForParticipant :one
+SELECT conversations.* FROM conversations
+JOIN conversation_participants ON conversation_participants.conversation_id = conversations.id
+WHERE conversations.id = $1 AND conversation_participants.user_id = $2;
+
+-- name: GetConversationParticipants :many
+SELECT user_id, joined_at, last_read_at FROM conversation_participants
+WHERE conversation_id = $1
+ORDER BY joined_at, user_id;
+
+-- name: GetConversationsByUser :many
+-- Most recently active first, with how many messages from others the user hasn't read.
+SELECT conversations.*,
+  ARRAY(
+    SELECT others.user_id FROM conversation_participants others
+    WHERE others.conversation_id = conversations.id
+    ORDER BY others.joined_at, others.user_id
+  )::uuid[] AS participant_ids,
+  (
+    SELECT COUNT(*) FROM messages
+    WHERE messages.conversation_id = conversations.id
+      AND messages.sender_id <> conversation_participants.user_id
+      AND messages.created_at > conversation_participants.last_read_at
+  ) AS unread_count
+FROM conversations
+JOIN conversation_participants ON conversation_participants.conversation_id = conversations.id
+WHERE conversation_participants.user_id = sqlc.arg('user_id')
+ORDER BY conversations.updated_at DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: CreateMessage :one
+-- Sending a message bumps the conversation and counts as the sender reading it.
+WITH touched AS (
+  UPDATE conversations SET updated_at = NOW() WHERE id = sqlc.arg('conversation_id')
+), read AS (
+  UPDATE conversation_participants SET last_read_at = NOW()
+  WHERE conversation_id = sqlc.arg('conversation_id') AND user_id = sqlc.arg('sender_id')
+)
+INSERT INTO messages (id, created_at, conversation_id, sender_id, body)
+VALUES (gen_random_uuid(), NOW(), sqlc.arg('conversation_id'), sqlc.arg('sender_id'), sqlc.arg('body'))
+RETURNING *;
+
+-- name: GetMessages :many
+-- Newest first. Messages from users the viewer has blocked, or been blocked by, are left out.
+SELECT messages.* FROM messages
+WHERE messages.conversation_id = sqlc.arg('conversation_id')
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE (blocks.blocker_id = sqlc.arg('viewer_id') AND blocks.blocked_id = messages.sender_id)
+       OR (blocks.blocker_id = messages.sender_id AND blocks.blocked_id = sqlc.arg('viewer_id'))
+  )
+ORDER BY messages.created_at DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: MarkConversationRead :exec
+UPDATE conversation_participants SET last_read_at = NOW()
+WHERE conversation_id = $1 AND user_id = $2;
+
+-- name: GetAllMessagesForUser :many
+-- Every message in the user's conversations, with the same block filter as GetMessages.
+SELECT messages.* FROM messages
+JOIN conversation_participants ON conversation_participants.conversation_id = messages.conversation_id
+WHERE conversation_participants.user_id = sqlc.arg('user_id')
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE (blocks.blocker_id = sqlc.arg('user_id') AND blocks.blocked_id = messages.sender_id)
+       OR (blocks.blocker_id = messages.sender_id AND blocks.blocked_id = sqlc.arg('user_id'))
+  )
+ORDER BY messages.conversation_id, messages.created_at;
@@ -0,0 +1,44 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, created_at, updated_at, user_id, status)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, 'pending')
+RETURNING *;
+
+-- name: CountActiveDataExports :one
+SELECT COUNT(*) FROM data_exports
+WHERE user_id = $1 AND status IN ('pending', 'processing');
+
+-- name: GetDataExportsByUser :many
+SELECT id, created_at, updated_at, user_id, status, expires_at FROM data_exports
+WHERE user_id = $1 ORDER BY created_at DESC;
+
+-- name: GetDataExport :one
+SELECT id, created_at, updated_at, user_id, status, expires_at FROM data_exports
+WHERE id = $1 AND user_id = $2;
+
+-- name: ClaimDataExport :one
+-- Exports left processing by a worker that died are picked up again after 10 minutes.
+UPDATE data_exports SET status = 'processing', updated_at = NOW()
+WHERE id = (
+  SELECT id FROM data_exports
+  WHERE status = 'pending' OR (status = 'processing' AND updated_at < NOW() - INTERVAL '10 minutes')
+  ORDER BY created_at
+  FOR UPDATE SKIP LOCKED
+  LIMIT 1
+)
+RETURNING id, user_id;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status = 'ready', archive = $2, download_token_hash = $3, expires_at = $4, updated_at = NOW()
+WHERE id = $1;
+
+-- name: FailDataExport :exec
+UPDATE data_exports SET status = 'failed', updated_at = NOW() WHERE id = $1;
+
+-- name: ExpireDataExports :exec
+UPDATE data_exports SET status = 'expired', archive = NULL, download_token_hash = NULL, updated_at = NOW()
+WHERE status = 'ready' AND expires_at <= NOW();
+
+-- name: GetDataExportArchive :one
+SELECT user_id, archive, download_token_hash FROM data_exports
+WHERE id = $1 AND status = 'ready' AND expires_at > NOW();
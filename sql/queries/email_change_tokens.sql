@@ -0,0 +1,13 @@
+-- name: CreateEmailChangeToken :exec
+INSERT INTO email_change_tokens (token_hash, created_at, user_id, new_email, expires_at, used_at)
+VALUES ($1, NOW(), $2, $3, $4, NULL);
+
+-- name: CancelEmailChanges :exec
+-- Only the most recently requested change can be confirmed.
+UPDATE email_change_tokens SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: UseEmailChangeToken :one
+UPDATE email_change_tokens SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id, new_email;
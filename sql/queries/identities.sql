@@ -0,0 +1,21 @@
+-- name: CreateIdentity :one
+INSERT INTO identities (id, created_at, updated_at, user_id, issuer, subject, email)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetIdentity :one
+SELECT * FROM identities WHERE issuer = $1 AND subject = $2;
+
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state, created_at, nonce, code_verifier, expires_at)
+VALUES ($1, NOW(), $2, $3, $4);
+
+-- name: UseOIDCLoginState :one
+DELETE FROM oidc_login_states WHERE state = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: RemoveExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states WHERE expires_at <= NOW();
+
+-- name: GetIdentitiesByUser :many
+SELECT * FROM identities WHERE user_id = $1 ORDER BY created_at;
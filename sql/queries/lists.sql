@@ -0,0 +1,58 @@
+-- name: CreateList :one
+INSERT INTO lists (id, created_at, updated_at, owner_id, name, description, is_private)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetList :one
+SELECT * FROM lists WHERE id = $1;
+
+-- name: GetListsByOwner :many
+-- Private lists are only included for their owner.
+SELECT * FROM lists
+WHERE owner_id = sqlc.arg('owner_id') AND (NOT is_private OR sqlc.arg('include_private')::bool)
+ORDER BY created_at DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: UpdateList :one
+UPDATE lists SET
+  name = COALESCE(sqlc.narg('name'), name),
+  description = COALESCE(sqlc.narg('description'), description),
+  is_private = COALESCE(sqlc.narg('is_private'), is_private),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: RemoveList :exec
+DELETE FROM lists WHERE id = $1;
+
+-- name: CountListMembers :one
+SELECT COUNT(*) FROM list_members WHERE list_id = $1;
+
+-- name: AddListMember :exec
+INSERT INTO list_members (list_id, user_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveListMember :execrows
+DELETE FROM list_members WHERE list_id = $1 AND user_id = $2;
+
+-- name: GetListMembers :many
+-- Members who have blocked the viewer, or who the viewer has blocked, are left out.
+SELECT sqlc.embed(users), list_members.created_at AS added_at
+FROM list_members JOIN users ON users.id = list_members.user_id
+WHERE list_members.list_id = sqlc.arg('list_id')
+  AND users.deleted_at IS NULL
+  AND (users.status = 'active' OR users.status_expires_at <= NOW())
+  AND NOT EXISTS (
+    SELECT 1 FROM blocks
+    WHERE (blocks.blocker_id = sqlc.narg('viewer_id')::uuid AND blocks.blocked_id = users.id)
+       OR (blocks.blocker_id = users.id AND blocks.blocked_id = sqlc.narg('viewer_id')::uuid)
+  )
+ORDER BY list_members.created_at DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: GetListMemberIDs :many
+SELECT user_id FROM list_members WHERE list_id = $1;
+
+-- name: GetAllListsByOwner :many
+SELECT * FROM lists WHERE owner_id = $1 ORDER BY created_at;
@@ -0,0 +1,39 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, owner_id, name, secret_hash, redirect_uris)
+VALUES ($1, NOW(), NOW(), $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id = $1;
+
+-- name: GetOAuthClientsByOwner :many
+SELECT * FROM oauth_clients WHERE owner_id = $1 ORDER BY created_at;
+
+-- name: RemoveOAuthClient :execrows
+DELETE FROM oauth_clients WHERE id = $1 AND owner_id = $2;
+
+-- name: UpsertOAuthConsent :exec
+INSERT INTO oauth_consents (user_id, client_id, created_at, updated_at, scopes)
+VALUES ($1, $2, NOW(), NOW(), $3)
+ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, updated_at = NOW();
+
+-- name: GetOAuthConsentsByUser :many
+SELECT oauth_consents.*, oauth_clients.name AS client_name
+FROM oauth_consents JOIN oauth_clients ON oauth_clients.id = oauth_consents.client_id
+WHERE oauth_consents.user_id = $1
+ORDER BY oauth_consents.created_at;
+
+-- name: GetOAuthConsent :one
+SELECT * FROM oauth_consents WHERE user_id = $1 AND client_id = $2;
+
+-- name: RemoveOAuthConsent :execrows
+DELETE FROM oauth_consents WHERE user_id = $1 AND client_id = $2;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, created_at, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, used_at)
+VALUES ($1, NOW(), $2, $3, $4, $5, $6, $7, NULL);
+
+-- name: UseOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes SET used_at = NOW()
+WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
@@ -0,0 +1,23 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (token_hash, created_at, user_id, expires_at, used_at)
+VALUES ($1, NOW(), $2, $3, NULL);
+
+-- name: CountRecentPasswordResetTokens :one
+SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = $1 AND created_at > $2;
+
+-- name: UsePasswordResetToken :one
+-- Using a token also uses up every other token the user has outstanding.
+WITH used AS (
+  UPDATE password_reset_tokens SET used_at = NOW()
+  WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+  RETURNING user_id
+), others AS (
+  UPDATE password_reset_tokens SET used_at = NOW()
+  WHERE user_id IN (SELECT user_id FROM used) AND used_at IS NULL AND token_hash <> $1
+)
+SELECT user_id FROM used;
+
+-- name: GetUserFromPasswordResetToken :one
+SELECT users.* FROM users
+JOIN password_reset_tokens ON password_reset_tokens.user_id = users.id
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW();
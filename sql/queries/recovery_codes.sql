@@ -0,0 +1,10 @@
+-- name: CreateRecoveryCodes :exec
+INSERT INTO recovery_codes (code_hash, created_at, user_id, used_at)
+SELECT unnest(@code_hashes::text[]), NOW(), @user_id, NULL;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: RemoveRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
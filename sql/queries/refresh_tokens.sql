@@ -4,7 +4,41 @@ VALUES ($1, NOW(), NOW(), $2, $3, NULL)
 RETURNING *;
 
 -- name: GetUserFromRefreshToken :one
-SELECT user_id FROM refresh_tokens WHERE revoked_at IS NULL AND token = $1;
+SELECT user_id FROM refresh_tokens WHERE revoked_at IS NULL AND client_id IS NULL AND token = $1;
 
--- name: RevokeRefreshToken :exec
-UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW() WHERE token = $1; 
+-- name: RevokeRefreshToken :one
+UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW() WHERE token = $1
+RETURNING user_id;
+
+-- name: GetSessionsByUser :many
+SELECT created_at, expires_at, client_id, scopes FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY created_at DESC;
+
+-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: CreateOAuthRefreshToken :one
+INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scopes)
+VALUES ($1, NOW(), NOW(), $2, $3, NULL, $4, $5)
+RETURNING *;
+
+-- name: GetOAuthRefreshToken :one
+SELECT * FROM refresh_tokens
+WHERE token = $1 AND client_id = $2 AND revoked_at IS NULL AND expires_at > NOW();
+
+-- name: RevokeOAuthRefreshToken :execrows
+UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW()
+WHERE token = $1 AND client_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeOAuthRefreshTokensForClient :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND client_id = $2 AND revoked_at IS NULL;
+
+-- name: IsSessionActive :one
+-- Finds the session by a hash of its refresh token, as stream tokens carry.
+SELECT EXISTS (
+  SELECT 1 FROM refresh_tokens
+  WHERE encode(sha256(token::bytea), 'hex') = sqlc.arg('token_hash') AND user_id = sqlc.arg('user_id')
+    AND client_id IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+);
@@ -0,0 +1,24 @@
+-- name: CreateStreamEvent :exec
+INSERT INTO stream_events (created_at, event_type, actor_id, recipient_ids, data)
+VALUES (NOW(), $1, $2, $3, $4);
+
+-- name: GetStreamEvent :one
+SELECT * FROM stream_events WHERE id = $1;
+
+-- name: GetStreamEventsAfter :many
+-- Public events and events for the recipient, oldest first.
+SELECT * FROM stream_events
+WHERE id > sqlc.arg('after_id')
+  AND (cardinality(recipient_ids) = 0 OR sqlc.arg('recipient_id')::uuid = ANY(recipient_ids))
+ORDER BY id
+LIMIT sqlc.arg('max_results');
+
+-- name: GetLatestStreamEventID :one
+SELECT COALESCE(MAX(id), 0)::bigint FROM stream_events;
+
+-- name: PurgeStreamEvents :execrows
+DELETE FROM stream_events WHERE created_at < $1;
+
+-- name: GetAllStreamEventsAfter :many
+-- Used to catch up on events missed while the listener was reconnecting.
+SELECT * FROM stream_events WHERE id > $1 ORDER BY id LIMIT sqlc.arg('max_results');
@@ -3,16 +3,98 @@ INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
 RETURNING *;
 
--- name: UpdateUserLogin :one
-UPDATE users SET email = $2, hashed_password = $3 WHERE id = $1
-RETURNING *;  
+-- name: UpdateUserPassword :exec
+UPDATE users SET hashed_password = $2, updated_at = NOW() WHERE id = $1;
+
+-- name: UpdateUserEmail :one
+-- The new address has been confirmed, so it's verified too.
+UPDATE users SET email = $2, is_verified = true, updated_at = NOW() WHERE id = $1
+RETURNING *;
 
 -- name: UpgradeUserToRed :one
 UPDATE users SET is_chirpy_red = true WHERE id = $1
 RETURNING *;
 
+-- name: VerifyUser :one
+UPDATE users SET is_verified = true, updated_at = NOW() WHERE id = $1
+RETURNING *;
+
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: SetTOTPSecret :exec
+UPDATE users SET totp_secret = $2, totp_enabled = false, updated_at = NOW() WHERE id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE users SET totp_enabled = true, updated_at = NOW() WHERE id = $1;
+
+-- name: DisableTOTP :exec
+UPDATE users SET totp_secret = NULL, totp_enabled = false, updated_at = NOW() WHERE id = $1;
+
+-- name: UpdateTOTPLastStep :execrows
+UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2;
+
 -- name: GetUserByEmail :one
 SELECT * FROM users WHERE email = $1;
 
--- name: RemoveUsers :exec
-DELETE FROM users;
+-- name: PromoteFirstAdmin :one
+UPDATE users SET role = 'admin', updated_at = NOW()
+WHERE email = $1 AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin')
+RETURNING *;
+
+-- name: SetUserRole :one
+UPDATE users SET role = $2, updated_at = NOW() WHERE id = $1
+RETURNING *;
+
+-- name: SearchUsers :many
+SELECT * FROM users
+WHERE sqlc.narg('query')::text IS NULL OR strpos(lower(email), lower(sqlc.narg('query'))) > 0
+ORDER BY created_at
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
+
+-- name: SetUserStatus :one
+UPDATE users SET status = $2, status_reason = $3, status_expires_at = $4, updated_at = NOW() WHERE id = $1
+RETURNING *;
+
+-- name: SetUserChirpyRed :one
+UPDATE users SET is_chirpy_red = $2, updated_at = NOW() WHERE id = $1
+RETURNING *;
+
+-- name: MarkUserDeleted :exec
+UPDATE users SET deleted_at = NOW(), updated_at = NOW() WHERE id = $1;
+
+-- name: RestoreUser :one
+UPDATE users SET deleted_at = NULL, updated_at = NOW() WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING *;
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users WHERE deleted_at < $1;
+
+-- name: RemoveUser :execrows
+DELETE FROM users WHERE id = $1;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users WHERE lower(handle) = lower($1);
+
+-- name: UpdateUserProfile :one
+-- Fields left NULL keep their current value.
+UPDATE users SET
+  handle = COALESCE(sqlc.narg('handle'), handle),
+  display_name = COALESCE(sqlc.narg('display_name'), display_name),
+  bio = COALESCE(sqlc.narg('bio'), bio),
+  location = COALESCE(sqlc.narg('location'), location),
+  website = COALESCE(sqlc.narg('website'), website),
+  updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: PinChirp :one
+-- Only the chirp's author can pin it.
+UPDATE users SET pinned_chirp_id = sqlc.arg('chirp_id'), updated_at = NOW()
+WHERE id = sqlc.arg('user_id')
+  AND EXISTS (SELECT 1 FROM chirps WHERE chirps.id = sqlc.arg('chirp_id') AND chirps.user_id = sqlc.arg('user_id'))
+RETURNING *;
+
+-- name: UnpinChirp :execrows
+UPDATE users SET pinned_chirp_id = NULL, updated_at = NOW()
+WHERE id = $1 AND pinned_chirp_id = $2;
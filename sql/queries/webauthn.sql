@@ -0,0 +1,28 @@
+-- name: CreateWebauthnChallenge :exec
+INSERT INTO webauthn_challenges (challenge, created_at, user_id, ceremony, expires_at)
+VALUES ($1, NOW(), $2, $3, $4);
+
+-- name: UseWebauthnChallenge :one
+DELETE FROM webauthn_challenges
+WHERE challenge = $1 AND ceremony = $2 AND expires_at > NOW()
+RETURNING *;
+
+-- name: RemoveExpiredWebauthnChallenges :exec
+DELETE FROM webauthn_challenges WHERE expires_at <= NOW();
+
+-- name: CreateWebauthnCredential :one
+INSERT INTO webauthn_credentials (id, created_at, updated_at, user_id, name, public_key, sign_count)
+VALUES ($1, NOW(), NOW(), $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetWebauthnCredential :one
+SELECT * FROM webauthn_credentials WHERE id = $1;
+
+-- name: GetWebauthnCredentialsByUser :many
+SELECT * FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at;
+
+-- name: UpdateWebauthnCredentialUsage :exec
+UPDATE webauthn_credentials SET sign_count = $2, last_used_at = NOW(), updated_at = NOW() WHERE id = $1;
+
+-- name: RemoveWebauthnCredential :execrows
+DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2;
@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE users
+  ADD COLUMN totp_secret TEXT,
+  ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+  code_hash TEXT PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE recovery_codes;
+ALTER TABLE users
+  DROP COLUMN totp_secret,
+  DROP COLUMN totp_enabled,
+  DROP COLUMN totp_last_step;
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE webauthn_credentials (
+  id TEXT PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  public_key BYTEA NOT NULL,
+  sign_count BIGINT NOT NULL,
+  last_used_at TIMESTAMP
+);
+
+CREATE TABLE webauthn_challenges (
+  challenge TEXT PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+  ceremony TEXT NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webauthn_challenges;
+DROP TABLE webauthn_credentials;
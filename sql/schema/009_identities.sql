@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE identities (
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  issuer TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  email TEXT NOT NULL,
+  UNIQUE (issuer, subject)
+);
+
+CREATE TABLE oidc_login_states (
+  state TEXT PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  nonce TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE oidc_login_states;
+DROP TABLE identities;
@@ -0,0 +1,37 @@
+-- +goose Up
+CREATE TABLE audit_events (
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  event_type TEXT NOT NULL,
+  -- Not foreign keys, events have to outlive the users they mention.
+  actor_id UUID,
+  target_id UUID,
+  ip TEXT NOT NULL,
+  user_agent TEXT NOT NULL,
+  request_id TEXT NOT NULL,
+  details JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX audit_events_event_type_idx ON audit_events (event_type, id);
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id, id);
+CREATE INDEX audit_events_target_id_idx ON audit_events (target_id, id);
+
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_no_update_or_delete
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+BEFORE TRUNCATE ON audit_events
+FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- +goose Down
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only;
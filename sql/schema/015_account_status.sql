@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'active'
+  CHECK (status IN ('active', 'suspended', 'banned'));
+ALTER TABLE users ADD COLUMN status_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN status_expires_at TIMESTAMP;
+
+UPDATE users SET status = 'suspended', status_reason = suspension_reason
+WHERE suspended_at IS NOT NULL;
+
+ALTER TABLE users DROP COLUMN suspension_reason;
+ALTER TABLE users DROP COLUMN suspended_at;
+
+-- +goose Down
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN suspension_reason TEXT NOT NULL DEFAULT '';
+
+UPDATE users SET suspended_at = updated_at, suspension_reason = status_reason
+WHERE status <> 'active' AND (status_expires_at IS NULL OR status_expires_at > NOW());
+
+ALTER TABLE users DROP COLUMN status_expires_at;
+ALTER TABLE users DROP COLUMN status_reason;
+ALTER TABLE users DROP COLUMN status;
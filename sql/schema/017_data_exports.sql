@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE data_exports (
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'processing', 'ready', 'failed', 'expired')),
+  archive BYTEA,
+  download_token_hash TEXT,
+  expires_at TIMESTAMP
+);
+
+CREATE INDEX data_exports_status_idx ON data_exports (status, created_at);
+
+-- +goose Down
+DROP TABLE data_exports;
@@ -0,0 +1,29 @@
+-- +goose Up
+ALTER TABLE users
+  ADD COLUMN handle TEXT,
+  ADD COLUMN display_name TEXT NOT NULL DEFAULT '',
+  ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+  ADD COLUMN location TEXT NOT NULL DEFAULT '',
+  ADD COLUMN website TEXT NOT NULL DEFAULT '',
+  ADD COLUMN avatar_updated_at TIMESTAMP;
+
+CREATE UNIQUE INDEX users_handle_idx ON users (lower(handle));
+
+-- Avatars are kept out of users so SELECT * on users doesn't load images.
+CREATE TABLE avatars (
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  updated_at TIMESTAMP NOT NULL,
+  content_type TEXT NOT NULL,
+  image BYTEA NOT NULL
+);
+
+-- +goose Down
+DROP TABLE avatars;
+DROP INDEX users_handle_idx;
+ALTER TABLE users
+  DROP COLUMN handle,
+  DROP COLUMN display_name,
+  DROP COLUMN bio,
+  DROP COLUMN location,
+  DROP COLUMN website,
+  DROP COLUMN avatar_updated_at;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- Bookmarks go when their chirp is deleted, by its author or an admin.
+CREATE TABLE bookmarks (
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (user_id, chirp_id)
+);
+
+CREATE INDEX bookmarks_chirp_id_idx ON bookmarks (chirp_id);
+
+-- +goose Down
+DROP TABLE bookmarks;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- Events pushed to streaming clients. Every server instance is told about new
+-- rows with NOTIFY, and clients that reconnect catch up from here.
+CREATE TABLE stream_events (
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  event_type TEXT NOT NULL,
+  actor_id UUID,
+  -- Empty for public events.
+  recipient_ids UUID[] NOT NULL DEFAULT '{}',
+  data JSONB NOT NULL
+);
+
+CREATE INDEX stream_events_created_at_idx ON stream_events (created_at);
+
+-- +goose StatementBegin
+CREATE FUNCTION stream_events_notify() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('stream_events', NEW.id::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER stream_events_notify
+AFTER INSERT ON stream_events
+FOR EACH ROW EXECUTE FUNCTION stream_events_notify();
+
+-- +goose Down
+DROP TABLE stream_events;
+DROP FUNCTION stream_events_notify;
@@ -0,0 +1,33 @@
+-- +goose Up
+-- One to one conversations keep their two participants sorted, so the pair can
+-- be unique and two requests starting the same conversation can't both create it.
+ALTER TABLE conversations
+  ADD COLUMN pair_low_id UUID,
+  ADD COLUMN pair_high_id UUID,
+  ADD CONSTRAINT conversations_pair_sorted CHECK (pair_low_id < pair_high_id);
+
+-- Where a pair already has several conversations only the oldest gets the key.
+-- The others keep their messages but aren't returned when the pair starts a
+-- conversation again.
+UPDATE conversations SET pair_low_id = pairs.ids[1], pair_high_id = pairs.ids[2]
+FROM (
+  SELECT DISTINCT ON (ids) id, ids FROM (
+    SELECT conversations.id, conversations.created_at,
+      array_agg(conversation_participants.user_id ORDER BY conversation_participants.user_id) AS ids
+    FROM conversations
+    JOIN conversation_participants ON conversation_participants.conversation_id = conversations.id
+    WHERE NOT conversations.is_group
+    GROUP BY conversations.id
+  ) direct
+  WHERE cardinality(ids) = 2
+  ORDER BY ids, created_at
+) pairs
+WHERE conversations.id = pairs.id;
+
+CREATE UNIQUE INDEX conversations_pair_idx ON conversations (pair_low_id, pair_high_id) WHERE NOT is_group;
+
+-- +goose Down
+DROP INDEX conversations_pair_idx;
+ALTER TABLE conversations
+  DROP COLUMN pair_low_id,
+  DROP COLUMN pair_high_id;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- 015 normally drops these. Databases created while 014 was missing from the
+-- tree apply it late, out of order, which adds the columns back after 015.
+ALTER TABLE users DROP COLUMN IF EXISTS suspension_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
+
+-- +goose Down
+-- Nothing to undo, 015's down recreates the columns.
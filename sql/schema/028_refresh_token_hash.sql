@@ -0,0 +1,8 @@
+-- +goose Up
+-- Stream tokens refer to their session by the refresh token's SHA-256, the
+-- same hash auth.HashToken makes. Refresh tokens are hex, so the cast to bytea
+-- gives the same bytes Go hashes.
+CREATE INDEX refresh_tokens_token_hash_idx ON refresh_tokens ((encode(sha256(token::bytea), 'hex')));
+
+-- +goose Down
+DROP INDEX refresh_tokens_token_hash_idx;
@@ -0,0 +1,38 @@
+-- +goose Up
+-- Times were stored without a time zone, but some come from NOW() in the
+-- database session's zone and others from Go. Any zone other than UTC put them
+-- out by the offset, so every timestamp column now carries its zone. Existing
+-- values are read in the zone of the session running the migration, which is
+-- the zone NOW() filled them in with.
+-- +goose StatementBegin
+DO $$
+DECLARE
+  col record;
+BEGIN
+  FOR col IN
+    SELECT table_name, column_name FROM information_schema.columns
+    WHERE table_schema = current_schema() AND data_type = 'timestamp without time zone'
+      AND table_name <> 'goose_db_version'
+  LOOP
+    EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE TIMESTAMPTZ', col.table_name, col.column_name);
+  END LOOP;
+END;
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DO $$
+DECLARE
+  col record;
+BEGIN
+  FOR col IN
+    SELECT table_name, column_name FROM information_schema.columns
+    WHERE table_schema = current_schema() AND data_type = 'timestamp with time zone'
+      AND table_name <> 'goose_db_version'
+  LOOP
+    EXECUTE format('ALTER TABLE %I ALTER COLUMN %I TYPE TIMESTAMP', col.table_name, col.column_name);
+  END LOOP;
+END;
+$$;
+-- +goose StatementEnd
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/samthesomebody/chirpy/internal/auth"
+	"github.com/samthesomebody/chirpy/internal/database"
+)
+
+const (
+	streamChirpCreated   = "chirp.created"
+	streamChirpDeleted   = "chirp.deleted"
+	streamMessageCreated = "message.created"
+)
+
+const (
+	streamHeartbeatInterval = time.Second * 15
+	streamEventRetention    = time.Hour * 24
+	streamReplayLimit       = 1000
+	streamBufferSize        = 64
+)
+
+// streamHub fans events out to the streaming clients connected to this
+// instance. Events reach it through Postgres NOTIFY, whichever instance
+// published them, so every instance sees the same events.
+type streamHub struct {
+	mu          sync.Mutex
+	subscribers map[*streamSubscriber]struct{}
+}
+
+type streamSubscriber struct {
+	userID uuid.UUID
+	events chan database.StreamEvent
+	// dropped is closed if the subscriber falls too far behind. The client
+	// reconnects and catches up with Last-Event-ID.
+	dropped chan struct{}
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{subscribers: map[*streamSubscriber]struct{}{}}
+}
+
+func (h *streamHub) subscribe(userID uuid.UUID) *streamSubscriber {
+	sub := &streamSubscriber{
+		userID:  userID,
+		events:  make(chan database.StreamEvent, streamBufferSize),
+		dropped: make(chan struct{}),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (h *streamHub) unsubscribe(sub *streamSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, sub)
+}
+
+// broadcast never blocks, subscribers that can't keep up are dropped.
+func (h *streamHub) broadcast(event database.StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		if len(event.RecipientIds) > 0 && !slices.Contains(event.RecipientIds, sub.userID) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+// run listens for new events until ctx is done. It also clears out events
+// too old to be worth replaying.
+func (h *streamHub) run(ctx context.Context, dbURL string) {
+	listener := pq.NewListener(dbURL, time.Second*10, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Error in stream listener: %v\n", err)
+		}
+	})
+	defer listener.Close()
+	err := listener.Listen("stream_events")
+	if err != nil {
+		log.Printf("Error listening for stream events: %v\n", err)
+		return
+	}
+
+	lastID, err := apiCfg.DB.GetLatestStreamEventID(ctx)
+	if err != nil {
+		log.Printf("Error retreiving latest stream event: %v\n", err)
+	}
+
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			if n == nil {
+				// The connection was lost and re-established, notifications
+				// sent in between never arrived.
+				params := database.GetAllStreamEventsAfterParams{ID: lastID, MaxResults: streamReplayLimit}
+				events, err := apiCfg.DB.GetAllStreamEventsAfter(ctx, params)
+				if err != nil {
+					log.Printf("Error retreiving missed stream events: %v\n", err)
+					continue
+				}
+				for _, event := range events {
+					h.broadcast(event)
+					lastID = max(lastID, event.ID)
+				}
+				continue
+			}
+			id, err := strconv.ParseInt(n.Extra, 10, 64)
+			if err != nil {
+				log.Printf("Error parsing stream event id: %v\n", err)
+				continue
+			}
+			event, err := apiCfg.DB.GetStreamEvent(ctx, id)
+			if err != nil {
+				log.Printf("Error retreiving stream event: %v\n", err)
+				continue
+			}
+			h.broadcast(event)
+			lastID = max(lastID, event.ID)
+		case <-ping.C:
+			go listener.Ping()
+		case <-purge.C:
+			cutoff := time.Now().Add(-streamEventRetention)
+			_, err := apiCfg.DB.PurgeStreamEvents(ctx, cutoff)
+			if err != nil {
+				log.Printf("Error purging stream events: %v\n", err)
+			}
+		}
+	}
+}
+
+// publishStreamEvent saves the event for every instance to deliver. Events
+// with no recipients go to everyone. Failures are logged rather than failing the request.
+func publishStreamEvent(ctx context.Context, eventType string, actorID uuid.UUID, recipientIDs []uuid.UUID, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshalling stream event [%v]: %v\n", eventType, err)
+		return
+	}
+	if recipientIDs == nil {
+		recipientIDs = []uuid.UUID{}
+	}
+
+	params := database.CreateStreamEventParams{
+		EventType:    eventType,
+		ActorID:      uuid.NullUUID{UUID: actorID, Valid: actorID != uuid.Nil},
+		RecipientIds: recipientIDs,
+		Data:         payload,
+	}
+	err = apiCfg.DB.CreateStreamEvent(ctx, params)
+	if err != nil {
+		log.Printf("Error publishing stream event [%v]: %v\n", eventType, err)
+	}
+}
+
+// streamFilter hides events from users the subscriber has blocked or been
+// blocked by, and chirp events from users they've muted.
+type streamFilter struct {
+	blocked []uuid.UUID
+	muted   []uuid.UUID
+}
+
+func loadStreamFilter(ctx context.Context, userID uuid.UUID) (streamFilter, error) {
+	blocked, err := apiCfg.DB.GetBlockedEitherWayIDs(ctx, userID)
+	if err != nil {
+		return streamFilter{}, err
+	}
+	muted, err := apiCfg.DB.GetMutedIDs(ctx, userID)
+	if err != nil {
+		return streamFilter{}, err
+	}
+	return streamFilter{blocked, muted}, nil
+}
+
+func (f streamFilter) allows(event database.StreamEvent) bool {
+	if !event.ActorID.Valid {
+		return true
+	}
+	if slices.Contains(f.blocked, event.ActorID.UUID) {
+		return false
+	}
+	return !(strings.HasPrefix(event.EventType, "chirp.") && slices.Contains(f.muted, event.ActorID.UUID))
+}
+
+func writeStreamEvent(w http.ResponseWriter, event database.StreamEvent) error {
+	var data bytes.Buffer
+	err := json.Compact(&data, event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.EventType, data.Bytes())
+	return err
+}
+
+// getMissedStreamEvents returns the user's events since afterID, oldest first.
+// If there are too many to replay it returns none, and the id of the latest
+// event to reset the client to instead.
+func getMissedStreamEvents(ctx context.Context, userID uuid.UUID, afterID int64) ([]database.StreamEvent, int64, error) {
+	params := database.GetStreamEventsAfterParams{AfterID: afterID, RecipientID: userID, MaxResults: streamReplayLimit + 1}
+	missed, err := apiCfg.DB.GetStreamEventsAfter(ctx, params)
+	if err != nil || len(missed) <= streamReplayLimit {
+		return missed, 0, err
+	}
+	latest, err := apiCfg.DB.GetLatestStreamEventID(ctx)
+	return nil, latest, err
+}
+
+var errStreamSessionEnded = errors.New("session the stream token was made from has ended")
+
+// checkStreamAccount ends streams for users who've been restricted or deleted
+// since they connected. Streams opened with a stream token also end with the
+// session it was made from.
+func checkStreamAccount(ctx context.Context, userID uuid.UUID, sessionHash string) error {
+	userDB, err := apiCfg.DB.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("retreiving user: %w", err)
+	}
+	err = checkAccountStatus(userDB)
+	if err != nil || sessionHash == "" {
+		return err
+	}
+
+	params := database.IsSessionActiveParams{TokenHash: sessionHash, UserID: userID}
+	active, err := apiCfg.DB.IsSessionActive(ctx, params)
+	if err != nil {
+		return fmt.Errorf("checking session: %w", err)
+	}
+	if !active {
+		return errStreamSessionEnded
+	}
+	return nil
+}
+
+// authenticateStream also accepts a stream token in the token query parameter,
+// as browsers can't set headers on an EventSource. It returns the session hash
+// from the stream token, which is empty for the Authorization header.
+func authenticateStream(req *http.Request) (uuid.UUID, string, error) {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		userID, err := authenticateUser(req, "")
+		return userID, "", err
+	}
+	stream, err := auth.ValidateStreamToken(token, apiCfg.TokenSecret)
+	if err != nil {
+		return uuid.UUID{}, "", err
+	}
+	return stream.UserID, stream.SessionHash, checkStreamAccount(req.Context(), stream.UserID, stream.SessionHash)
+}
+
+// handlerCreateStreamToken gives browsers a token to open the stream with. It
+// takes the refresh token, like /api/refresh, so the stream token can be tied
+// to that session and outlive the JWT.
+func handlerCreateStreamToken(w http.ResponseWriter, req *http.Request) {
+	refreshToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		log.Printf("Error finding refresh token in request header: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	userID, err := apiCfg.DB.GetUserFromRefreshToken(req.Context(), refreshToken)
+	if err != nil {
+		log.Printf("Error finding refresh token database entry: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	sessionHash := auth.HashToken(refreshToken)
+	err = checkStreamAccount(req.Context(), userID, sessionHash)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	token, err := auth.MakeStreamToken(userID, sessionHash, apiCfg.TokenSecret)
+	if err != nil {
+		log.Printf("Error generating stream token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{token, time.Now().Add(auth.StreamTokenExpiry)})
+}
+
+// handlerStream pushes new chirps, chirp deletions and direct messages as
+// server-sent events. Clients that reconnect with Last-Event-ID are sent what
+// they missed first.
+func handlerStream(w http.ResponseWriter, req *http.Request) {
+	userID, sessionHash, err := authenticateStream(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Printf("Error streaming: response writer can't flush\n")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var lastEventID int64
+	raw := req.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = req.URL.Query().Get("last_event_id")
+	}
+	if raw != "" {
+		lastEventID, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || lastEventID < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+	}
+
+	filter, err := loadStreamFilter(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error loading stream filter: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before replaying so nothing published in between is missed.
+	sub := apiCfg.Stream.subscribe(userID)
+	defer apiCfg.Stream.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 3000\n\n")
+
+	var replayedUpTo int64
+	if raw != "" {
+		missed, resetTo, err := getMissedStreamEvents(req.Context(), userID, lastEventID)
+		if err != nil {
+			log.Printf("Error retreiving missed stream events: %v\n", err)
+			return
+		}
+		if resetTo > 0 {
+			// Too much was missed to replay, the client has to reload instead.
+			replayedUpTo = resetTo
+			_, err = fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", resetTo)
+			if err != nil {
+				return
+			}
+		}
+		for _, event := range missed {
+			replayedUpTo = event.ID
+			if filter.allows(event) && writeStreamEvent(w, event) != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-sub.dropped:
+			return
+		case event := <-sub.events:
+			if event.ID <= replayedUpTo || !filter.allows(event) {
+				continue
+			}
+			if writeStreamEvent(w, event) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			err = checkStreamAccount(req.Context(), userID, sessionHash)
+			if err != nil {
+				log.Printf("Ending stream: %v\n", err)
+				return
+			}
+			// Blocks and mutes may have changed since the stream started.
+			filter, err = loadStreamFilter(req.Context(), userID)
+			if err != nil {
+				log.Printf("Error loading stream filter: %v\n", err)
+				return
+			}
+			_, err = fmt.Fprintf(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
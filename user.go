@@ -4,8 +4,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/mail"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,31 +24,65 @@ type LoginDetails struct {
 }
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	Token        string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
-	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	ID            uuid.UUID  `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Email         string     `json:"email"`
+	Token         string     `json:"token"`
+	RefreshToken  string     `json:"refresh_token"`
+	IsChirpyRed   bool       `json:"is_chirpy_red"`
+	IsVerified    bool       `json:"is_verified"`
+	Role          string     `json:"role"`
+	Status        string     `json:"status"`
+	Handle        *string    `json:"handle"`
+	DisplayName   string     `json:"display_name"`
+	Bio           string     `json:"bio"`
+	Location      string     `json:"location"`
+	Website       string     `json:"website"`
+	AvatarURL     *string    `json:"avatar_url"`
+	PinnedChirpID *uuid.UUID `json:"pinned_chirp_id"`
 }
 
 func mapToUser(from database.User) User {
-	return User{
+	user := User{
 		ID:          from.ID,
 		CreatedAt:   from.CreatedAt,
 		UpdatedAt:   from.UpdatedAt,
 		Email:       from.Email,
 		IsChirpyRed: from.IsChirpyRed,
+		IsVerified:  from.IsVerified,
+		Role:        from.Role,
+		Status:      effectiveStatus(from),
+		DisplayName: from.DisplayName,
+		Bio:         from.Bio,
+		Location:    from.Location,
+		Website:     from.Website,
+		AvatarURL:   avatarURL(from),
 	}
+	if from.Handle.Valid {
+		user.Handle = &from.Handle.String
+	}
+	if from.PinnedChirpID.Valid {
+		user.PinnedChirpID = &from.PinnedChirpID.UUID
+	}
+	return user
 }
 
 func handlerAddUser(w http.ResponseWriter, req *http.Request) {
 	var details LoginDetails
 	err := json.NewDecoder(req.Body).Decode(&details)
 	if err != nil {
-		log.Printf("Error decoding request body: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
+		return
+	}
+
+	address, err := mail.ParseAddress(details.Email)
+	if err != nil || address.Address != details.Email {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+	if !checkPassword(w, details.Password, details.Email) {
+		return
 	}
 
 	password, err := auth.HashPassword(details.Password)
@@ -61,13 +98,36 @@ func handlerAddUser(w http.ResponseWriter, req *http.Request) {
 	}
 	userDB, err := apiCfg.DB.CreateUser(req.Context(), params)
 	if err != nil {
-		log.Fatal(err)
+		// Deleted accounts keep their email until they're purged.
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "That email address is already in use.")
+			return
+		}
+		log.Printf("Error creating user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = sendVerificationEmail(req.Context(), userDB)
+	if err != nil {
+		log.Printf("Error sending verification email: %v\n", err)
 	}
 
 	user := mapToUser(userDB)
 	respondWithJSON(w, http.StatusCreated, user)
 }
 
+// dummyPasswordHash is checked against for unknown emails, so a login takes
+// as long whether or not the account exists.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	random, _ := auth.MakeRefreshToken() // err is always nil
+	hash, err := auth.HashPassword(random)
+	if err != nil {
+		log.Printf("Error hashing dummy password: %v\n", err)
+	}
+	return hash
+})
+
 func handlerLoginUser(w http.ResponseWriter, req *http.Request) {
 	var details LoginDetails
 	err := json.NewDecoder(req.Body).Decode(&details)
@@ -81,24 +141,101 @@ func handlerLoginUser(w http.ResponseWriter, req *http.Request) {
 		details.ExpiresInSeconds = 3600
 	}
 
-	userDB, err := apiCfg.DB.GetUserByEmail(req.Context(), details.Email)
+	accountKey, ipKey := accountLoginKey(details.Email), ipLoginKey(req)
+	wait, err := loginRetryAfter(req.Context(), accountKey, ipKey)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
-			return
-		}
+		log.Printf("Error checking login failures: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		respondWithLoginLocked(w, wait)
+		return
+	}
+
+	userDB, err := apiCfg.DB.GetUserByEmail(req.Context(), details.Email)
+	if err == nil {
+		err = auth.CheckPasswordHash(details.Password, userDB.HashedPassword)
+	} else if errors.Is(err, sql.ErrNoRows) {
+		auth.CheckPasswordHash(details.Password, dummyPasswordHash())
+	} else {
 		log.Printf("Error retreiving user: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if err != nil {
+		handleLoginFailure(w, req, details.Email, userDB.ID, accountKey, ipKey)
+		return
+	}
 
-	err = auth.CheckPasswordHash(details.Password, userDB.HashedPassword)
+	err = apiCfg.DB.ClearLoginFailures(req.Context(), accountKey)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		log.Printf("Error clearing login failures: %v\n", err)
+	}
+
+	// Upgrade bcrypt hashes and hashes made with old parameters while we have the password.
+	if auth.NeedsRehash(userDB.HashedPassword) {
+		hash, err := auth.HashPassword(details.Password)
+		if err == nil {
+			params := database.UpdateUserPasswordParams{ID: userDB.ID, HashedPassword: hash}
+			err = apiCfg.DB.UpdateUserPassword(req.Context(), params)
+		}
+		if err != nil {
+			log.Printf("Error rehashing password: %v\n", err)
+		}
+	}
+
+	if userDB.TotpEnabled {
+		respondWithMFAChallenge(w, userDB)
 		return
 	}
 
-	token, err := auth.MakeJWT(userDB.ID, apiCfg.TokenSecret)
+	issueSession(w, req, userDB, "password")
+}
+
+// handleLoginFailure counts failures for unknown emails too, so lockouts don't reveal which accounts exist.
+func handleLoginFailure(w http.ResponseWriter, req *http.Request, email string, userID uuid.UUID, accountKey, ipKey string) {
+	recordPasswordFailure(req, "password", email, userID, accountKey, ipKey)
+	respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+}
+
+// recordPasswordFailure counts a wrong password against the account and IP,
+// whether it was for a login or to confirm a change to the account.
+func recordPasswordFailure(req *http.Request, method, email string, userID uuid.UUID, accountKey, ipKey string) {
+	recordAuditEvent(req, auditEvent{
+		Type:     auditLoginFailure,
+		TargetID: userID,
+		Details:  map[string]any{"method": method, "email": email},
+	})
+
+	lockedOut, err := recordLoginFailure(req.Context(), accountKey, accountLoginPolicy)
+	if err != nil {
+		log.Printf("Error recording login failure: %v\n", err)
+	}
+	if lockedOut {
+		recordAuditEvent(req, auditEvent{
+			Type:     auditLoginLocked,
+			TargetID: userID,
+			Details:  map[string]any{"email": email},
+		})
+		go sendLockoutEmail(email)
+	}
+	_, err = recordLoginFailure(req.Context(), ipKey, ipLoginPolicy)
+	if err != nil {
+		log.Printf("Error recording login failure: %v\n", err)
+	}
+}
+
+// issueSession responds with the user and a new JWT and refresh token, once
+// they've proven who they are by whichever login method.
+func issueSession(w http.ResponseWriter, req *http.Request, userDB database.User, method string) {
+	err := checkAccountStatus(userDB)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	token, err := auth.MakeJWT(userDB.ID, apiCfg.TokenSecret, time.Hour)
 	if err != nil {
 		log.Printf("Error generating JWT: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -118,27 +255,17 @@ func handlerLoginUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	recordAuditEvent(req, auditEvent{
+		Type:     auditLoginSuccess,
+		ActorID:  userDB.ID,
+		TargetID: userDB.ID,
+		Details:  map[string]any{"method": method},
+	})
+
 	user := mapToUser(userDB)
 	user.Token = token
 	user.RefreshToken = refresh_token
-	respondWithJSON(w, http.StatusCreated, user)
-}
-
-func handlerRemoveUsers(w http.ResponseWriter, req *http.Request) {
-	if apiCfg.Platform != "dev" {
-		respondWithError(w, http.StatusForbidden, "Invalid Permissions")
-		return
-	}
-
-	err := apiCfg.DB.RemoveUsers(req.Context())
-	if err != nil {
-		log.Printf("Error removing user: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
+	respondWithJSON(w, http.StatusOK, user)
 }
 
 func handlerRefreshJWT(w http.ResponseWriter, req *http.Request) {
@@ -163,7 +290,19 @@ func handlerRefreshJWT(w http.ResponseWriter, req *http.Request) {
 
 	}
 
-	jwt, err := auth.MakeJWT(userID, apiCfg.TokenSecret)
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
+	if err != nil {
+		log.Printf("Error retreiving user: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = checkAccountStatus(userDB)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	jwt, err := auth.MakeJWT(userID, apiCfg.TokenSecret, time.Hour)
 	if err != nil {
 		log.Printf("Error generating JWT: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -197,53 +336,176 @@ func handlerRevokeRefreshToken(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = apiCfg.DB.RevokeRefreshToken(req.Context(), token)
+	userID, err := apiCfg.DB.RevokeRefreshToken(req.Context(), token)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 		log.Printf("Error adjusting refresh token db entry: %v\n", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
+	recordAuditEvent(req, auditEvent{Type: auditRefreshTokenRevoke, ActorID: userID, TargetID: userID})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handlerUpdateUser only changes the fields present in the body. Changing the
+// email or password needs the current password, and a new email only takes
+// effect once it's been confirmed.
 func handlerUpdateUser(w http.ResponseWriter, req *http.Request) {
-	token, err := auth.GetBearerToken(req.Header)
+	userID, err := authenticateUser(req, "")
 	if err != nil {
-		log.Printf("Error getting refresh token: %v\n", err)
-		w.WriteHeader(http.StatusUnauthorized)
+		respondWithAuthError(w, err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, apiCfg.TokenSecret)
+	var details struct {
+		profileDetails
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&details)
 	if err != nil {
-		log.Printf("Error validating JWT: %v\n", err)
-		w.WriteHeader(http.StatusUnauthorized)
+		respondWithError(w, http.StatusBadRequest, "Incorrect body parameters")
 		return
 	}
 
-	var user database.UpdateUserLoginParams
-	err = json.NewDecoder(req.Body).Decode(&user)
+	profile, err := profileParams(userID, details.profileDetails)
 	if err != nil {
-		log.Printf("Error decoding refresh token: %v/n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	user.HashedPassword, err = auth.HashPassword(user.HashedPassword)
+	userDB, err := apiCfg.DB.GetUserByID(req.Context(), userID)
 	if err != nil {
-		log.Printf("Error hashing password: %v\n", err)
+		log.Printf("Error retreiving user: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	user.ID = userID
-	userDB, err := apiCfg.DB.UpdateUserLogin(req.Context(), user)
+	// Everything is checked before anything is written, so a request is
+	// either applied in full or not at all.
+	if details.Email != nil && *details.Email == userDB.Email {
+		details.Email = nil
+	}
+	if details.Email != nil || details.Password != nil {
+		// Guesses at the current password are throttled like logins, so a
+		// stolen access token can't be used to find it.
+		accountKey, ipKey := accountLoginKey(userDB.Email), ipLoginKey(req)
+		wait, err := loginRetryAfter(req.Context(), accountKey, ipKey)
+		if err != nil {
+			log.Printf("Error checking login failures: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			respondWithLoginLocked(w, wait)
+			return
+		}
+		err = auth.CheckPasswordHash(details.CurrentPassword, userDB.HashedPassword)
+		if err != nil {
+			recordPasswordFailure(req, "current_password", userDB.Email, userID, accountKey, ipKey)
+			respondWithError(w, http.StatusUnauthorized, "Incorrect current password")
+			return
+		}
+	}
+
+	if details.Email != nil {
+		address, err := mail.ParseAddress(*details.Email)
+		if err != nil || address.Address != *details.Email {
+			respondWithError(w, http.StatusBadRequest, "Invalid email address")
+			return
+		}
+		_, err = apiCfg.DB.GetUserByEmail(req.Context(), *details.Email)
+		if err == nil {
+			respondWithError(w, http.StatusConflict, "That email address is already in use.")
+			return
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error retreiving user: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if profile.Handle.Valid {
+		other, err := apiCfg.DB.GetUserByHandle(req.Context(), profile.Handle.String)
+		if err == nil && other.ID != userID {
+			respondWithError(w, http.StatusConflict, "That handle is already taken.")
+			return
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error retreiving user: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var passwordHash string
+	if details.Password != nil {
+		if !checkPassword(w, *details.Password, userDB.Email) {
+			return
+		}
+		passwordHash, err = auth.HashPassword(*details.Password)
+		if err != nil {
+			log.Printf("Error hashing password: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var changeToken string
+	err = withTx(req.Context(), func(q *database.Queries) error {
+		if details.Password != nil {
+			params := database.UpdateUserPasswordParams{ID: userID, HashedPassword: passwordHash}
+			err := q.UpdateUserPassword(req.Context(), params)
+			if err != nil {
+				return fmt.Errorf("updating password: %w", err)
+			}
+			// As with a reset, anyone else holding a session is logged out.
+			err = q.RevokeAllRefreshTokensForUser(req.Context(), userID)
+			if err != nil {
+				return fmt.Errorf("revoking refresh tokens: %w", err)
+			}
+		}
+
+		var err error
+		userDB, err = q.UpdateUserProfile(req.Context(), profile)
+		if err != nil {
+			return err
+		}
+
+		if details.Email != nil {
+			changeToken, err = createEmailChangeToken(req.Context(), q, userID, *details.Email)
+			if err != nil {
+				return fmt.Errorf("creating email change token: %w", err)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error updating user login details: %v\n", err)
+		// Someone may have taken the handle since it was checked.
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "That handle is already taken.")
+			return
+		}
+		log.Printf("Error updating user: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	respondWithJSON(w, 200, mapToUser(userDB))
+	// Only once committed, so the link is never for a token that was rolled back.
+	if details.Email != nil {
+		sendEmailChangeEmails(userDB, *details.Email, changeToken)
+	}
+	if details.Password != nil {
+		recordAuditEvent(req, auditEvent{Type: auditPasswordChange, ActorID: userID, TargetID: userID})
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		User
+		PendingEmail *string `json:"pending_email,omitempty"`
+	}{mapToUser(userDB), details.Email})
 }